@@ -2,4 +2,4 @@
 
 source "$(pwd)/bs/env.sh"
 
-$GO_BIN build -race
+$GO_BIN build -race -tags sqlite_fts5
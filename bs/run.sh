@@ -2,4 +2,4 @@
 
 source "$(pwd)/bs/build.sh"
 
-$GO_BIN run -race . "$DIR_OBSIDIAN"
+$GO_BIN run -race -tags sqlite_fts5 . "$DIR_OBSIDIAN"
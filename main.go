@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/docopt/docopt-go"
 	"github.com/google/gops/agent"
@@ -13,13 +16,36 @@ import (
 	diatom "github.com/rgrannell1/diatom/pkg"
 )
 
+// Report an error, and exit with a code distinguishing failed runs from partial ones
+func exit(err error) {
+	diatom.WriteErrorSummary(err, os.Stderr)
+	os.Exit(diatom.ExitCode(err))
+}
+
+// Report a usage error, and exit
+func usage(message string, err error) {
+	fmt.Fprintf(os.Stderr, "diatom: %v: %v\n", message, err)
+	os.Exit(diatom.EXIT_USAGE)
+}
+
 // Main function. Read from Obsidian & save as structured data.
 func main() {
-	opts, err := docopt.ParseDoc(diatom.Usage())
+	parser := &docopt.Parser{
+		HelpHandler: func(err error, usage string) {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, usage)
+				os.Exit(diatom.EXIT_USAGE)
+			}
+
+			fmt.Println(usage)
+			os.Exit(diatom.EXIT_OK)
+		},
+	}
+
+	opts, err := parser.ParseArgs(diatom.Usage(), nil, "")
 
 	if err != nil {
-		fmt.Printf("%+v\n", err)
-		os.Exit(1)
+		exit(err)
 	}
 
 	if err := agent.Listen(agent.Options{}); err != nil {
@@ -27,23 +53,163 @@ func main() {
 	}
 
 	dpath, _ := opts.String("<dpath>")
-	dbpath, _ := opts.String("<dbpath>")
+	cfgPath, _ := opts.String("--config")
 
-	home, err := os.UserHomeDir()
+	// a subcommand missing its arguments parses as a vault path, such as `check`
+	if dpath != "" {
+		info, err := os.Stat(dpath)
+
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("%v is not a directory", dpath)
+		}
+
+		if err != nil {
+			usage("<dpath> must be an existing vault folder", err)
+		}
+	}
+
+	cfg, err := diatom.LoadConfig(dpath, cfgPath)
 
 	if err != nil {
-		fmt.Printf("%+v\n", err)
-		os.Exit(1)
+		exit(err)
 	}
 
-	dbpath = filepath.Join(home, ".diatom.sqlite")
-	err = diatom.Diatom(&diatom.DiatomArgs{
+	// command-line options take precedence over the configuration file
+	if dbpath, err := opts.String("--dbpath"); err == nil && dbpath != "" {
+		cfg.DBPath = dbpath
+	}
+	if include, ok := opts["--include"].([]string); ok && len(include) > 0 {
+		cfg.Include = include
+	}
+	if exclude, ok := opts["--exclude"].([]string); ok && len(exclude) > 0 {
+		cfg.Exclude = exclude
+	}
+	if followSymlinks, _ := opts.Bool("--follow-symlinks"); followSymlinks {
+		cfg.FollowSymlinks = true
+	}
+	if verify, _ := opts.Bool("--verify"); verify {
+		cfg.Verify = true
+	}
+
+	args := &diatom.DiatomArgs{
 		Dir:    dpath,
-		DBPath: dbpath,
-	})
+		Config: cfg,
+	}
+
+	// the first interrupt stops new notes being read, and saves those already read;
+	// stop() restores the default handling, so a second interrupt exits at once
+	signalled, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-signalled.Done()
+		stop()
+	}()
+
+	ctx := signalled
+
+	if timeout, _ := opts.String("--timeout"); timeout != "" {
+		duration, err := time.ParseDuration(timeout)
+
+		if err != nil {
+			usage("--timeout must be a duration, such as 30s or 5m", err)
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	if migrate, _ := opts.Bool("migrate"); migrate {
+		dryRun, _ := opts.Bool("--dry-run")
+
+		if err := diatom.Migrate(args, dryRun, os.Stdout); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if watch, _ := opts.Bool("watch"); watch {
+		debounce, err := opts.Int("--debounce")
+
+		if err != nil {
+			usage("--debounce must be a number", err)
+		}
+
+		if err := diatom.Watch(ctx, args, time.Duration(debounce)*time.Millisecond, os.Stdout); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if search, _ := opts.Bool("search"); search {
+		query, _ := opts.String("<query>")
+		format, _ := opts.String("--format")
+		limit, err := opts.Int("--limit")
+
+		if err != nil {
+			usage("--limit must be a number", err)
+		}
+
+		if _, err := diatom.Search(args, query, limit, format, os.Stdout); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if tasks, _ := opts.Bool("tasks"); tasks {
+		format, _ := opts.String("--format")
+		filter := diatom.TaskFilter{}
+
+		statuses, _ := opts["--status"].([]string)
+		for _, status := range statuses {
+			chars, err := diatom.TaskStatus(status)
+			if err != nil {
+				usage("invalid --status", err)
+			}
+			filter.Statuses = append(filter.Statuses, chars...)
+		}
+
+		filter.Tags, _ = opts["--tag"].([]string)
+		filter.Paths, _ = opts["--path"].([]string)
+		filter.Date, _ = opts.String("--date")
+		filter.From, _ = opts.String("--from")
+		filter.To, _ = opts.String("--to")
+
+		if err := filter.Validate(); err != nil {
+			usage("invalid task filter", err)
+		}
+
+		if _, err := diatom.Tasks(args, filter, format, os.Stdout); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if check, _ := opts.Bool("check"); check {
+		format, _ := opts.String("--format")
+		failOn, _ := opts.String("--fail-on")
+
+		if failOn != diatom.SEVERITY_WARNING && failOn != diatom.SEVERITY_ERROR {
+			usage("--fail-on must be warning or error", fmt.Errorf("got %v", failOn))
+		}
+
+		failing, err := diatom.Check(ctx, args, format, failOn, os.Stdout)
+
+		// notes failing to index take precedence over the problems found
+		if err != nil {
+			exit(err)
+		}
+
+		if failing > 0 {
+			os.Exit(diatom.EXIT_PROBLEMS)
+		}
+		return
+	}
+
+	err = diatom.Diatom(ctx, args)
 
 	if err != nil {
-		fmt.Printf("%+v\n", err)
-		os.Exit(1)
+		exit(err)
 	}
 }
@@ -0,0 +1,198 @@
+package diatom
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Files Obsidian can embed, by extension, with their MIME types
+var ATTACHMENT_TYPES = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".bmp":  "image/bmp",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".pdf":  "application/pdf",
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".3gp":  "audio/3gpp",
+	".flac": "audio/flac",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+	".mov":  "video/quicktime",
+	".mkv":  "video/x-matroska",
+}
+
+/*
+ * The MIME type of an attachment, from its extension
+ *
+ */
+func AttachmentType(fpath string) (string, bool) {
+	mimeType, ok := ATTACHMENT_TYPES[strings.ToLower(path.Ext(fpath))]
+	return mimeType, ok
+}
+
+/*
+ * Compute a SHA-256 hash of a file, hex-encoded. The file is streamed,
+ * so large videos are not read into memory.
+ *
+ */
+func HashFile(fpath string) (string, error) {
+	file, err := os.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Indexes the images, PDFs, audio and video in a vault
+type AttachmentWorker struct {
+	Stats  *Stats
+	Config *DiatomConfig
+	Vault  *ObsidianVault
+}
+
+/*
+ * Index every attachment in the vault, and remove vanished ones. As with notes,
+ * attachments with an unchanged mtime and size are not re-read unless verifying,
+ * and unreadable attachments are recorded in the stats. Returns the vault-relative
+ * paths of attachments added, changed or removed.
+ *
+ */
+func (worker *AttachmentWorker) Start(ctx context.Context, conn *ObsidianDB) ([]string, error) {
+	stored, err := conn.GetAttachmentStates()
+	if err != nil {
+		return nil, errors.Wrap(err, "failure reading attachment states")
+	}
+
+	fpaths, walkErrors := worker.Vault.GetAttachments(ctx)
+
+	// the note walk reports the same errors; here they only stop attachments being removed
+	walkDone := make(chan bool, 1)
+	go func() {
+		failed := false
+		for err := range walkErrors {
+			var symlinkErr *SymlinkError
+			if !errors.As(err, &symlinkErr) {
+				failed = true
+			}
+		}
+
+		walkDone <- failed
+	}()
+
+	seen := map[string]bool{}
+	changed := []string{}
+	attachments := []*Attachment{}
+
+	for fpath := range fpaths {
+		rel := worker.Vault.RelPath(fpath)
+		seen[rel] = true
+
+		attachment, err := worker.Read(fpath, rel, stored)
+		if err != nil {
+			worker.Stats.Fail(&NoteError{rel, FAILURE_READ, err})
+			continue
+		}
+
+		if attachment == nil {
+			worker.Stats.Add(COUNT_ATTACHMENT_CACHED)
+			continue
+		}
+
+		if state, known := stored[rel]; !known || state.Hash != attachment.Hash {
+			changed = append(changed, rel)
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	walkFailed := <-walkDone
+
+	if err := conn.WriteAttachments(attachments); err != nil {
+		return nil, errors.Wrap(err, "failure writing attachments")
+	}
+	for range attachments {
+		worker.Stats.Add(COUNT_ATTACHMENT_WRITTEN)
+	}
+
+	// an interrupted or failed walk may not have seen every attachment
+	if ctx.Err() != nil || walkFailed {
+		return changed, nil
+	}
+
+	removed := []string{}
+	for rel := range stored {
+		if !seen[rel] {
+			removed = append(removed, rel)
+		}
+	}
+
+	if err := conn.DeleteAttachments(removed); err != nil {
+		return nil, errors.Wrap(err, "failure removing attachments")
+	}
+	for range removed {
+		worker.Stats.Add(COUNT_ATTACHMENT_REMOVED)
+	}
+
+	return append(changed, removed...), nil
+}
+
+/*
+ * Read an attachment's size, mtime and hash. Returns nil when the
+ * stored attachment is unchanged.
+ *
+ */
+func (worker *AttachmentWorker) Read(fpath, rel string, stored map[string]FileState) (*Attachment, error) {
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	mtime, size := info.ModTime().UnixNano(), info.Size()
+	state, known := stored[rel]
+	sameStat := known && state.Mtime == mtime && state.Size == size
+
+	if sameStat && !worker.Config.Verify {
+		return nil, nil
+	}
+
+	hash, err := HashFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if sameStat && state.Hash == hash {
+		return nil, nil
+	}
+
+	mimeType, _ := AttachmentType(rel)
+
+	return &Attachment{
+		Path:      rel,
+		Extension: strings.ToLower(strings.TrimPrefix(path.Ext(rel), ".")),
+		MimeType:  mimeType,
+		Size:      size,
+		Mtime:     mtime,
+		Hash:      hash,
+	}, nil
+}
@@ -0,0 +1,148 @@
+package diatom
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+/*
+ * Index a vault's attachments into a database, returning the paths changed
+ * and the counts recorded
+ *
+ */
+func indexAttachments(t *testing.T, conn *ObsidianDB, dir string) ([]string, map[string]int) {
+	t.Helper()
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vault := NewVault(dir, cfg)
+	worker := AttachmentWorker{Stats: NewStats(), Config: cfg, Vault: &vault}
+
+	changed, err := worker.Start(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	sort.Strings(changed)
+
+	return changed, worker.Stats.Data
+}
+
+func TestAttachmentWorkerStart(t *testing.T) {
+	conn := openTestDB(t)
+	if err := conn.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeVault(t, map[string]string{
+		"a.png":     "one",
+		"x/b.pdf":   "two",
+		"c.png":     "three",
+		"note.md":   "![[a.png]]\n",
+		"notes.txt": "not an attachment",
+	})
+
+	changed, counts := indexAttachments(t, conn, dir)
+	if want := []string{"a.png", "c.png", "x/b.pdf"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("first run changed %v; want %v", changed, want)
+	}
+	if counts[COUNT_ATTACHMENT_WRITTEN] != 3 {
+		t.Errorf("first run counts = %v; want 3 written", counts)
+	}
+
+	// a.png is unchanged, b.pdf is rewritten and c.png is deleted
+	if err := os.WriteFile(filepath.Join(dir, "x", "b.pdf"), []byte("two, rewritten"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "c.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, counts = indexAttachments(t, conn, dir)
+	if want := []string{"c.png", "x/b.pdf"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("second run changed %v; want %v", changed, want)
+	}
+	want := map[string]int{COUNT_ATTACHMENT_CACHED: 1, COUNT_ATTACHMENT_WRITTEN: 1, COUNT_ATTACHMENT_REMOVED: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("second run counts = %v; want %v", counts, want)
+	}
+
+	stored, err := conn.GetAttachmentStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashFile(filepath.Join(dir, "x", "b.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored["c.png"]; ok || len(stored) != 2 || stored["x/b.pdf"].Hash != hash {
+		t.Errorf("stored attachments = %v; want a.png, and b.pdf with its new hash", stored)
+	}
+
+	// a walk that fails may not have seen every attachment, so removes none
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, counts = indexAttachments(t, conn, dir)
+	if len(changed) != 0 || counts[COUNT_ATTACHMENT_REMOVED] != 0 {
+		t.Errorf("run over a missing vault changed %v, counts %v; want nothing removed", changed, counts)
+	}
+	if stored, err := conn.GetAttachmentStates(); err != nil || len(stored) != 2 {
+		t.Errorf("stored attachments after a failed walk = %v, %v; want both kept", stored, err)
+	}
+}
+
+func TestAttachmentWorkerRead(t *testing.T) {
+	dir := writeVault(t, map[string]string{"a.png": "one"})
+	fpath := filepath.Join(dir, "a.png")
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault := NewVault(dir, cfg)
+	worker := AttachmentWorker{Stats: NewStats(), Config: cfg, Vault: &vault}
+
+	attachment, err := worker.Read(fpath, "a.png", map[string]FileState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment == nil || attachment.Extension != "png" || attachment.MimeType != "image/png" || attachment.Size != 3 {
+		t.Fatalf("Read() of a new attachment = %+v; want a 3 byte png", attachment)
+	}
+
+	stored := map[string]FileState{"a.png": {Hash: attachment.Hash, Mtime: attachment.Mtime, Size: attachment.Size}}
+	if cached, err := worker.Read(fpath, "a.png", stored); err != nil || cached != nil {
+		t.Errorf("Read() of an unchanged attachment = %+v, %v; want it cached", cached, err)
+	}
+
+	// rewritten with the same size and mtime, only a verifying read notices
+	if err := os.WriteFile(fpath, []byte("two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(0, attachment.Mtime)
+	if err := os.Chtimes(fpath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if cached, err := worker.Read(fpath, "a.png", stored); err != nil || cached != nil {
+		t.Errorf("Read() of an attachment with the same stat = %+v, %v; want it cached", cached, err)
+	}
+
+	cfg.Verify = true
+	verified, err := worker.Read(fpath, "a.png", stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified == nil || verified.Hash == attachment.Hash {
+		t.Errorf("verifying Read() of a rewritten attachment = %+v; want its new hash", verified)
+	}
+}
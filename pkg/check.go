@@ -0,0 +1,243 @@
+package diatom
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const PROBLEM_BROKEN_LINK = "broken-link"
+const PROBLEM_BROKEN_HEADING = "broken-heading"
+const PROBLEM_ORPHAN = "orphan"
+const PROBLEM_BROKEN_EMBED = "broken-embed"
+const PROBLEM_BROKEN_BLOCK = "broken-block"
+const PROBLEM_UNUSED_ATTACHMENT = "unused-attachment"
+
+// Diagnostics are reported as a problem of kind "invalid-<extractor>", such as invalid-frontmatter
+const PROBLEM_INVALID = "invalid-"
+
+const FORMAT_TEXT = "text"
+const FORMAT_JSON = "json"
+const FORMAT_CHECKSTYLE = "checkstyle"
+
+// A link-hygiene problem found in a vault. Problems with the whole file, such
+// as orphan notes, have no line.
+type Problem struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+}
+
+/*
+ * Find broken wikilinks and embeds, links to missing headings and blocks, orphan notes,
+ * unused attachments, and problems recorded while extracting notes
+ *
+ */
+func (conn *ObsidianDB) Check() ([]Problem, error) {
+	problems := []Problem{}
+
+	diagnostics, err := conn.GetDiagnostics()
+	if err != nil {
+		return problems, err
+	}
+	problems = append(problems, diagnostics...)
+
+	brokenLinks, err := conn.GetBrokenLinks()
+	if err != nil {
+		return problems, err
+	}
+	problems = append(problems, brokenLinks...)
+
+	brokenEmbeds, err := conn.GetBrokenEmbeds()
+	if err != nil {
+		return problems, err
+	}
+	problems = append(problems, brokenEmbeds...)
+
+	brokenHeadings, err := conn.GetBrokenHeadingLinks()
+	if err != nil {
+		return problems, err
+	}
+	problems = append(problems, brokenHeadings...)
+
+	brokenBlocks, err := conn.GetBrokenBlockLinks()
+	if err != nil {
+		return problems, err
+	}
+	problems = append(problems, brokenBlocks...)
+
+	orphans, err := conn.GetOrphans()
+	if err != nil {
+		return problems, err
+	}
+	problems = append(problems, orphans...)
+
+	unused, err := conn.GetUnusedAttachments()
+	if err != nil {
+		return problems, err
+	}
+	problems = append(problems, unused...)
+
+	sort.SliceStable(problems, func(idx, jdx int) bool {
+		if problems[idx].File != problems[jdx].File {
+			return problems[idx].File < problems[jdx].File
+		}
+		if problems[idx].Line != problems[jdx].Line {
+			return problems[idx].Line < problems[jdx].Line
+		}
+		return problems[idx].Column < problems[jdx].Column
+	})
+
+	return problems, nil
+}
+
+/*
+ * Count the problems failing a check: every problem when failing on warnings,
+ * or only errors when failing on errors
+ *
+ */
+func FailingProblems(problems []Problem, failOn string) int {
+	count := 0
+	for _, problem := range problems {
+		if failOn == SEVERITY_WARNING || problem.Severity == SEVERITY_ERROR {
+			count++
+		}
+	}
+
+	return count
+}
+
+/*
+ * Index a vault, run a link-hygiene check against it, and write a report.
+ * Returns the number of problems at or above the failOn severity, so by
+ * default warnings such as orphan notes fail the check too; notes that failed
+ * to index are returned as a RunErrors after the report is written.
+ *
+ */
+func Check(ctx context.Context, args *DiatomArgs, format, failOn string, out io.Writer) (int, error) {
+	if format != FORMAT_TEXT && format != FORMAT_JSON && format != FORMAT_CHECKSTYLE {
+		return 0, fmt.Errorf("unknown report format %v (expected %v, %v or %v)", format, FORMAT_TEXT, FORMAT_JSON, FORMAT_CHECKSTYLE)
+	}
+
+	if failOn != SEVERITY_WARNING && failOn != SEVERITY_ERROR {
+		return 0, fmt.Errorf("unknown severity %v for --fail-on (expected %v or %v)", failOn, SEVERITY_WARNING, SEVERITY_ERROR)
+	}
+
+	var runErrors *RunErrors
+
+	indexErr := Diatom(ctx, args)
+	if indexErr != nil && !errors.As(indexErr, &runErrors) {
+		return 0, indexErr
+	}
+
+	conn, err := NewDB(args.Config.DBPath)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	problems, err := conn.Check()
+	if err != nil {
+		return 0, err
+	}
+
+	failing := FailingProblems(problems, failOn)
+
+	if err := WriteReport(problems, format, out); err != nil {
+		return failing, err
+	}
+
+	return failing, indexErr
+}
+
+/*
+ * Write problems in text, JSON, or checkstyle format
+ *
+ */
+func WriteReport(problems []Problem, format string, out io.Writer) error {
+	switch format {
+	case FORMAT_TEXT:
+		for _, problem := range problems {
+			location := problem.File
+			if problem.Line > 0 {
+				location += fmt.Sprintf(":%v", problem.Line)
+
+				if problem.Column > 0 {
+					location += fmt.Sprintf(":%v", problem.Column)
+				}
+			}
+
+			if _, err := fmt.Fprintf(out, "%v: %v: %v: %v\n", location, problem.Severity, problem.Kind, problem.Message); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FORMAT_JSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(problems)
+	case FORMAT_CHECKSTYLE:
+		return writeCheckstyle(problems, out)
+	}
+
+	return fmt.Errorf("unknown report format %v (expected %v, %v or %v)", format, FORMAT_TEXT, FORMAT_JSON, FORMAT_CHECKSTYLE)
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr,omitempty"`
+	Column   int    `xml:"column,attr,omitempty"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleReport struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+func writeCheckstyle(problems []Problem, out io.Writer) error {
+	report := checkstyleReport{Version: "4.3"}
+
+	// problems are sorted by file, so group adjacent problems
+	for _, problem := range problems {
+		if len(report.Files) == 0 || report.Files[len(report.Files)-1].Name != problem.File {
+			report.Files = append(report.Files, checkstyleFile{Name: problem.File})
+		}
+
+		file := &report.Files[len(report.Files)-1]
+		file.Errors = append(file.Errors, checkstyleError{
+			Line:     problem.Line,
+			Column:   problem.Column,
+			Severity: problem.Severity,
+			Message:  problem.Message,
+			Source:   "diatom." + problem.Kind,
+		})
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(out, "\n")
+	return err
+}
@@ -0,0 +1,183 @@
+package diatom
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+/*
+ * A small vault's notes and attachments, written and resolved in an
+ * in-memory database
+ *
+ */
+func checkedDB(t *testing.T) *ObsidianDB {
+	t.Helper()
+
+	conn := openTestDB(t)
+	if err := conn.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeResults(t, conn,
+		noteResult(t, "a.md", "# A\n[[b]] [[missing]] [[b#Nope]] [[b#B]] [[b#^blk]] [[b#^nope]] ![[used.png]] ![[gone.png]]\n"),
+		noteResult(t, "b.md", "# B\n\npara ^blk\n\n[[a]]\n"),
+		noteResult(t, "self.md", "only [[self]]\n"),
+		noteResult(t, "lonely.md", "no links\n"),
+	)
+
+	attachments := []*Attachment{
+		{Path: "used.png", Extension: ".png", MimeType: "image/png"},
+		{Path: "unused.png", Extension: ".png", MimeType: "image/png"},
+	}
+	if err := conn.WriteAttachments(attachments); err != nil {
+		t.Fatal(err)
+	}
+
+	resolvers := ResolveWorker{Stats: NewStats()}
+	if err := resolvers.Start(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestCheckProblems(t *testing.T) {
+	conn := checkedDB(t)
+
+	cases := []struct {
+		name  string
+		check func() ([]Problem, error)
+		want  []Problem
+	}{
+		{
+			name:  "broken links",
+			check: conn.GetBrokenLinks,
+			want: []Problem{
+				{"a.md", 2, 7, SEVERITY_ERROR, PROBLEM_BROKEN_LINK, "[[missing]] does not resolve to a note"},
+			},
+		},
+		{
+			name:  "broken embeds",
+			check: conn.GetBrokenEmbeds,
+			want: []Problem{
+				{"a.md", 2, 76, SEVERITY_ERROR, PROBLEM_BROKEN_EMBED, "![[gone.png]] does not resolve to a note or attachment"},
+			},
+		},
+		{
+			name:  "links to missing headings",
+			check: conn.GetBrokenHeadingLinks,
+			want: []Problem{
+				{"a.md", 2, 19, SEVERITY_ERROR, PROBLEM_BROKEN_HEADING, `[[b#Nope]] links to missing heading "Nope"`},
+			},
+		},
+		{
+			name:  "links to missing blocks",
+			check: conn.GetBrokenBlockLinks,
+			want: []Problem{
+				{"a.md", 2, 49, SEVERITY_ERROR, PROBLEM_BROKEN_BLOCK, "[[b#^nope]] links to missing block ^nope"},
+			},
+		},
+		{
+			name:  "orphans, including notes linking only to themselves",
+			check: conn.GetOrphans,
+			want: []Problem{
+				{"lonely.md", 0, 0, SEVERITY_WARNING, PROBLEM_ORPHAN, "note has no links to or from other notes"},
+				{"self.md", 0, 0, SEVERITY_WARNING, PROBLEM_ORPHAN, "note has no links to or from other notes"},
+			},
+		},
+		{
+			name:  "unused attachments",
+			check: conn.GetUnusedAttachments,
+			want: []Problem{
+				{"unused.png", 0, 0, SEVERITY_WARNING, PROBLEM_UNUSED_ATTACHMENT, "attachment is not linked or embedded by any note"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.check()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sortProblems(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("problems:\n got  %+v\n want %+v", got, tc.want)
+			}
+		})
+	}
+
+	// a check reports every kind, sorted by file and line
+	problems, err := conn.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 7 || problems[0].File != "a.md" || problems[len(problems)-1].File != "unused.png" {
+		t.Errorf("Check() = %+v; want the seven problems above, sorted by file", problems)
+	}
+}
+
+func TestFailingProblems(t *testing.T) {
+	problems := []Problem{
+		{File: "a.md", Line: 1, Severity: SEVERITY_ERROR, Kind: PROBLEM_BROKEN_LINK},
+		{File: "b.md", Severity: SEVERITY_WARNING, Kind: PROBLEM_ORPHAN},
+		{File: "c.png", Severity: SEVERITY_WARNING, Kind: PROBLEM_UNUSED_ATTACHMENT},
+	}
+
+	if got := FailingProblems(problems, SEVERITY_WARNING); got != 3 {
+		t.Errorf("FailingProblems(warning) = %v; want every problem, 3", got)
+	}
+	if got := FailingProblems(problems, SEVERITY_ERROR); got != 1 {
+		t.Errorf("FailingProblems(error) = %v; want only errors, 1", got)
+	}
+	if got := FailingProblems(problems[1:], SEVERITY_WARNING); got != 2 {
+		t.Errorf("FailingProblems(warning) of warnings alone = %v; want 2", got)
+	}
+}
+
+func TestWriteCheckstyle(t *testing.T) {
+	problems := []Problem{
+		{"a.md", 2, 7, SEVERITY_ERROR, PROBLEM_BROKEN_LINK, "[[missing]] does not resolve to a note"},
+		{"a.md", 3, 0, SEVERITY_ERROR, PROBLEM_BROKEN_HEADING, `[[b#Nope]] links to missing heading "Nope"`},
+		{"b.md", 0, 0, SEVERITY_WARNING, PROBLEM_ORPHAN, "note has no links to or from other notes"},
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<checkstyle version="4.3">
+  <file name="a.md">
+    <error line="2" column="7" severity="error" message="[[missing]] does not resolve to a note" source="diatom.broken-link"></error>
+    <error line="3" severity="error" message="[[b#Nope]] links to missing heading &#34;Nope&#34;" source="diatom.broken-heading"></error>
+  </file>
+  <file name="b.md">
+    <error severity="warning" message="note has no links to or from other notes" source="diatom.orphan"></error>
+  </file>
+</checkstyle>
+`
+
+	var out bytes.Buffer
+	if err := writeCheckstyle(problems, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("writeCheckstyle():\n got  %v\n want %v", out.String(), want)
+	}
+}
+
+/*
+ * Sort problems as Check does, by file, then line and column
+ *
+ */
+func sortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(idx, jdx int) bool {
+		if problems[idx].File != problems[jdx].File {
+			return problems[idx].File < problems[jdx].File
+		}
+		if problems[idx].Line != problems[jdx].Line {
+			return problems[idx].Line < problems[jdx].Line
+		}
+		return problems[idx].Column < problems[jdx].Column
+	})
+}
@@ -0,0 +1,169 @@
+package diatom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const CONFIG_FILE = "diatom.yaml"
+
+const EXTRACTOR_TAGS = "tags"
+const EXTRACTOR_URLS = "urls"
+const EXTRACTOR_WIKILINKS = "wikilinks"
+const EXTRACTOR_HEADINGS = "headings"
+const EXTRACTOR_BLOCKS = "blocks"
+const EXTRACTOR_SECTIONS = "sections"
+const EXTRACTOR_TASKS = "tasks"
+const EXTRACTOR_FIELDS = "fields"
+const EXTRACTOR_PROPERTIES = "properties"
+const EXTRACTOR_FRONTMATTER = "frontmatter"
+const EXTRACTOR_METADATA = "metadata"
+const EXTRACTOR_TEXT = "text"
+
+var EXTRACTORS = []string{
+	EXTRACTOR_TAGS,
+	EXTRACTOR_URLS,
+	EXTRACTOR_WIKILINKS,
+	EXTRACTOR_HEADINGS,
+	EXTRACTOR_BLOCKS,
+	EXTRACTOR_SECTIONS,
+	EXTRACTOR_TASKS,
+	EXTRACTOR_FIELDS,
+	EXTRACTOR_PROPERTIES,
+	EXTRACTOR_FRONTMATTER,
+	EXTRACTOR_METADATA,
+	EXTRACTOR_TEXT,
+}
+
+// Diatom configuration, read from diatom.yaml
+type DiatomConfig struct {
+	DBPath         string   `yaml:"dbpath"`
+	Workers        int      `yaml:"workers"`
+	Include        []string `yaml:"include"`
+	Exclude        []string `yaml:"exclude"`
+	FollowSymlinks bool     `yaml:"follow_symlinks"`
+	Verify         bool     `yaml:"verify"`
+	Extractors     []string `yaml:"extractors"`
+}
+
+/*
+ * Construct the default configuration
+ *
+ */
+func DefaultConfig() (*DiatomConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiatomConfig{
+		DBPath:     filepath.Join(home, ".diatom.sqlite"),
+		Workers:    WORKER_COUNT,
+		Include:    DEFAULT_INCLUDE,
+		Extractors: EXTRACTORS,
+	}, nil
+}
+
+/*
+ * Load configuration from an explicit path, or from diatom.yaml in
+ * the vault root when present. Without either, such as for commands
+ * taking no vault, the defaults are used. Unset fields keep their defaults.
+ *
+ */
+func LoadConfig(dpath, fpath string) (*DiatomConfig, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty vault path would read diatom.yaml from the working directory
+	explicit := fpath != ""
+	if !explicit && dpath == "" {
+		return cfg, nil
+	}
+	if !explicit {
+		fpath = filepath.Join(dpath, CONFIG_FILE)
+	}
+
+	content, err := os.ReadFile(fpath)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("LoadConfig() %v: %v", fpath, err)
+	}
+
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("LoadConfig() %v: %v", fpath, err)
+	}
+
+	// a relative database path is relative to the configuration file
+	cfg.DBPath = cfg.ResolvePath(cfg.DBPath, filepath.Dir(fpath))
+
+	return cfg, cfg.Validate()
+}
+
+/*
+ * Expand a leading ~, and resolve relative paths against a directory
+ *
+ */
+func (cfg *DiatomConfig) ResolvePath(fpath, dir string) string {
+	if fpath == "~" || strings.HasPrefix(fpath, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			fpath = filepath.Join(home, fpath[1:])
+		}
+	}
+
+	if !filepath.IsAbs(fpath) {
+		fpath = filepath.Join(dir, fpath)
+	}
+
+	return fpath
+}
+
+/*
+ * Check the configuration is usable
+ *
+ */
+func (cfg *DiatomConfig) Validate() error {
+	if cfg.DBPath == "" {
+		return errors.New("config: dbpath must not be empty")
+	}
+
+	if cfg.Workers < 1 {
+		return fmt.Errorf("config: workers must be at least 1, got %v", cfg.Workers)
+	}
+
+	for _, name := range cfg.Extractors {
+		known := false
+		for _, extractor := range EXTRACTORS {
+			known = known || name == extractor
+		}
+
+		if !known {
+			return fmt.Errorf("config: unknown extractor %v (expected one of %v)", name, strings.Join(EXTRACTORS, ", "))
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Is an extractor enabled?
+ *
+ */
+func (cfg *DiatomConfig) Enabled(extractor string) bool {
+	for _, name := range cfg.Extractors {
+		if name == extractor {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package diatom
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vault := t.TempDir()
+	other := t.TempDir()
+
+	files := map[string]string{
+		filepath.Join(vault, CONFIG_FILE): "dbpath: vault.sqlite\n",
+		filepath.Join(other, CONFIG_FILE): "dbpath: other.sqlite\n",
+	}
+	for fpath, content := range files {
+		if err := os.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an unrelated diatom.yaml in the working directory
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(other); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	cases := []struct {
+		name   string
+		dpath  string
+		fpath  string
+		dbpath string
+	}{
+		{"the vault's diatom.yaml", vault, "", filepath.Join(vault, "vault.sqlite")},
+		{"an explicit config", vault, filepath.Join(other, CONFIG_FILE), filepath.Join(other, "other.sqlite")},
+		{"no vault reads no diatom.yaml", "", "", filepath.Join(home, ".diatom.sqlite")},
+		{"no vault with an explicit config", "", filepath.Join(other, CONFIG_FILE), filepath.Join(other, "other.sqlite")},
+		{"a vault without diatom.yaml", t.TempDir(), "", filepath.Join(home, ".diatom.sqlite")},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := LoadConfig(tc.dpath, tc.fpath)
+			if err != nil {
+				t.Fatalf("LoadConfig(%q, %q): %v", tc.dpath, tc.fpath, err)
+			}
+
+			if cfg.DBPath != tc.dbpath {
+				t.Errorf("LoadConfig(%q, %q).DBPath = %q; want %q", tc.dpath, tc.fpath, cfg.DBPath, tc.dbpath)
+			}
+		})
+	}
+}
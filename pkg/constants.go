@@ -6,22 +6,217 @@ import (
 	"path/filepath"
 )
 
+// Default number of extract workers
 const WORKER_COUNT = 20
 
+// Number of notes written per transaction
+const WRITE_BATCH_SIZE = 200
+
+// Namespace for note ids generated from vault-relative paths
+var NOTE_ID_NAMESPACE = [16]byte{
+	0x6b, 0x1e, 0x2a, 0x4c, 0x9d, 0x53, 0x4f, 0x0e,
+	0x8a, 0x61, 0x3c, 0x27, 0xd4, 0xf0, 0x95, 0x12,
+}
+
+// Where an entity occurs in its note. Offsets are in bytes from the start of
+// the file, and the end is exclusive; lines and columns count from one, and
+// columns are in bytes. A zero line means the entity could not be located.
+type Position struct {
+	Start  int
+	End    int
+	Line   int
+	Column int
+}
+
+const WIKILINK_LINK = "link"
+const WIKILINK_NOTE_EMBED = "note-embed"
+const WIKILINK_ATTACHMENT_EMBED = "attachment-embed"
+
 // Wikilink data-structure
 type Wikilink struct {
 	Reference string
 	Alias     string
+	Target    string
+	Heading   string
+	BlockId   string
+	IsEmbed   bool
+	Type      string
+	// the heading's anchor slug, for matching against heading.slug
+	HeadingSlug string
+	// the id of the task whose text holds the wikilink, or zero
+	TaskId int
+	Position
+}
+
+// Url data-structure
+type Url struct {
+	Url    string
+	Scheme string
+	Host   string
+	Path   string
+	Text   string
+	Position
+}
+
+// Tag data-structure
+type Tag struct {
+	Tag string
+	// the id of the task whose text holds the tag, or zero
+	TaskId int
+	Position
+}
+
+// Markdown heading
+type Heading struct {
+	Level int
+	Text  string
+	Slug  string
+	Position
+}
+
+const BLOCK_PARAGRAPH = "paragraph"
+const BLOCK_LIST_ITEM = "list-item"
+const BLOCK_LIST = "list"
+const BLOCK_TABLE = "table"
+const BLOCK_QUOTE = "quote"
+const BLOCK_CODE = "code"
+
+// A block marked with a ^block-id, that wikilinks can reference
+type Block struct {
+	Id   string
+	Type string
+	Text string
+	Position
+}
+
+// A heading and the text beneath it, up to the next heading of the same or a higher level
+type Section struct {
+	// numbered from one, in document order
+	Id int
+	// the enclosing section's id, or zero for a top-level section
+	ParentId int
+	Level    int
+	Heading  string
+	Text     string
+	Position
+}
+
+// A checklist item, with any Tasks plugin metadata. Dates are YYYY-MM-DD, or empty when unset.
+type Task struct {
+	// numbered from one, in document order
+	Id int
+	// the enclosing task's id, or zero for a top-level task
+	ParentId      int
+	Status        string
+	Text          string
+	Priority      string
+	Recurrence    string
+	CreatedDate   string
+	StartDate     string
+	ScheduledDate string
+	DueDate       string
+	CancelledDate string
+	DoneDate      string
+	Position
+}
+
+// A Dataview inline field, such as `rating:: 5`, or a top-level frontmatter key
+type Field struct {
+	Source string
+	Key    string
+	Value  string
+	Type   string
+	// the innermost section and the task holding an inline field, or zero
+	SectionId int
+	TaskId    int
+	Position
+}
+
+// A frontmatter property, or one element of a list property. Its value is in the
+// column for its type; empty strings and nil pointers are unset.
+type Property struct {
+	Key string
+	// the element's index in a list, or -1 for a property that is not a list
+	Index   int
+	Type    string
+	Text    string
+	Number  *float64
+	Date    string
+	Boolean *bool
+	// the target of a wikilink in a text value
+	Link string
+}
+
+// Application-readable data, from frontmatter or a labelled code-block
+type Metadata struct {
+	Schema  string
+	Content string
+	Position
+}
+
+const SEVERITY_ERROR = "error"
+const SEVERITY_WARNING = "warning"
+
+// A problem found while extracting a note, such as invalid yaml. Column is zero when unknown.
+type Diagnostic struct {
+	Line      int
+	Column    int
+	Severity  string
+	Extractor string
+	Message   string
 }
 
 // All extracted data from markdown
 type MarkdownData struct {
-	Title     string
-	Wikilinks []*Wikilink
-	Tags      []string
-	Urls      []string
-	Hash      uint32
-	Headings  []Heading
+	Title       string
+	Wikilinks   []*Wikilink
+	Tags        []Tag
+	Urls        []*Url
+	Hash        string
+	Headings    []Heading
+	Blocks      []Block
+	Sections    []Section
+	Tasks       []Task
+	Fields      []Field
+	Properties  []Property
+	Frontmatter string
+	// the span of the frontmatter block, from the opening to the closing ---
+	FrontmatterPosition Position
+	Metadata            []Metadata
+	Text                string
+	Diagnostics         []Diagnostic
+}
+
+// The stored state of a note, used to skip unchanged notes
+type FileState struct {
+	Hash  string
+	Mtime int64
+	Size  int64
+	// the note was read with property types or extractors since changed
+	Stale bool
+}
+
+// An image, PDF, audio or video file in the vault
+type Attachment struct {
+	Path      string
+	Extension string
+	MimeType  string
+	Size      int64
+	Mtime     int64
+	Hash      string
+}
+
+// Data extracted from one note, sent from the extract workers to the writer
+type NoteResult struct {
+	Path          string
+	Data          *MarkdownData
+	Mtime         int64
+	Size          int64
+	StatOnly      bool
+	Added         bool
+	FrontmatterId string
+	RenamedFrom   string
+	RenameReason  string
 }
 
 // Obsidian database structure
@@ -32,7 +227,7 @@ type ObsidianDB struct {
 // CLI Arguments
 type DiatomArgs struct {
 	Dir    string
-	DBPath string
+	Config *DiatomConfig
 }
 
 // Obsidian note information
@@ -40,11 +235,24 @@ type ObsidianNote struct {
 	fpath       string
 	frontMatter map[string]interface{}
 	data        *MarkdownData
+	body        string
+	bodyLine    int
+	bodyOffset  int
+}
+
+// Text taken from a note, with the byte offset and line it starts at in the file
+type Source struct {
+	Text   string
+	Offset int
+	Line   int
 }
 
 // Obsidian vault data
 type ObsidianVault struct {
-	dpath string
+	dpath          string
+	include        []string
+	exclude        []string
+	followSymlinks bool
 }
 
 // Markdown note content
@@ -68,14 +276,61 @@ func Usage() string {
 
 	return `
 Usage:
-  diatom (<dpath>) [--dbpath <dbpath>]
+  diatom migrate [--dbpath <dbpath>] [--config <config>] [--dry-run]
+  diatom check (<dpath>) [--dbpath <dbpath>] [--config <config>] [--include <glob>...] [--exclude <glob>...] [--follow-symlinks] [--verify] [--timeout <duration>] [--format <format>] [--fail-on <severity>]
+  diatom search <query> [--dbpath <dbpath>] [--config <config>] [--limit <limit>] [--format <format>]
+  diatom tasks [--dbpath <dbpath>] [--config <config>] [--status <status>...] [--tag <tag>...] [--path <glob>...] [--date <field>] [--from <date>] [--to <date>] [--format <format>]
+  diatom watch (<dpath>) [--dbpath <dbpath>] [--config <config>] [--include <glob>...] [--exclude <glob>...] [--follow-symlinks] [--verify] [--debounce <ms>]
+  diatom (<dpath>) [--dbpath <dbpath>] [--config <config>] [--include <glob>...] [--exclude <glob>...] [--follow-symlinks] [--verify] [--timeout <duration>]
   diatom (-h | --help)
 
 Description:
   Extract structured data from an Obsidian vault into a sqlite database.
 
+  diatom check indexes the vault, then reports broken wikilinks and embeds, links to missing headings,
+  orphan notes, unused attachments, and invalid frontmatter or code-block yaml.
+
+  diatom watch indexes the vault, then keeps running and reindexes notes as they change.
+
+  diatom search finds notes matching a full-text query (SQLite FTS5 syntax), ranked by relevance.
+
+  diatom tasks lists the indexed - [ ] tasks matching every filter given, with their Tasks plugin
+  dates, priority and recurrence. A repeated filter matches any of its values.
+
+  diatom migrate brings the database schema up to date; indexing also does this automatically.
+  Diatom refuses to write to a database created by a newer version.
+
+  Settings are read from diatom.yaml in the vault root, or the file given with --config;
+  commands taking no vault, such as search, read only the file given with --config.
+  Command-line options take precedence over the configuration file.
+
+Exit status:
+  0  success
+  1  the run failed
+  2  invalid arguments, or a search query FTS5 cannot parse
+  3  the run completed, but some notes failed to index
+  4  diatom check found problems; with --fail-on error, only errors, and not warnings such as orphan notes
+  5  the run was interrupted, or timed out; notes already read were saved
+
 Options:
-  --dbpath <dbpath>       the path the diatom sqlite database [default: ` + dbPath + `]
+  --dbpath <dbpath>       the path the diatom sqlite database. Defaults to ` + dbPath + `
+  --config <config>       the path to a diatom.yaml configuration file
+  --include <glob>        vault-relative glob patterns for notes to read. Defaults to **/*.md
+  --exclude <glob>        vault-relative glob patterns for notes and folders to skip, as well as .trash/**
+  --follow-symlinks       follow symbolic links, rather than reporting and skipping them
+  --verify                read and hash every note, rather than skipping notes whose size and mtime are unchanged
+  --timeout <duration>    stop indexing after a duration such as 30s or 5m, keeping the notes already read
+  --dry-run               list pending migrations without applying them
+  --format <format>       the output format; text, json, or (for check) checkstyle [default: text]
+  --fail-on <severity>    the least severe problem failing diatom check: warning or error [default: warning]
+  --limit <limit>         the maximum number of search results [default: 20]
+  --status <status>       tasks with a status: todo, in-progress, done, cancelled, or a status character
+  --tag <tag>             tasks with a tag, or a tag nested within it
+  --path <glob>           tasks in notes matching a vault-relative glob pattern
+  --date <field>          the date compared by --from and --to: due, scheduled, start, created, done or cancelled [default: due]
+  --from <date>           tasks dated on or after a YYYY-MM-DD date
+  --to <date>             tasks dated on or before a YYYY-MM-DD date
+  --debounce <ms>         milliseconds to wait for file changes to settle before reindexing [default: 500]
 
 License:
 	The MIT License
@@ -104,3 +359,12 @@ const COUNT_FAILED_EXTRACTION = "count/failed_extraction"
 const COUNT_EXTRACT_NOTE = "count/extract_note"
 const COUNT_NOTE_CACHED = "count/note_cached"
 const COUNT_NOTE_UPDATED = "count/note_updated"
+const COUNT_NOTE_WRITTEN = "count/note_written"
+const COUNT_NOTE_RENAMED = "count/note_renamed"
+const COUNT_FAILED_WRITE = "count/failed_write"
+const COUNT_SYMLINK_SKIPPED = "count/symlink_skipped"
+const COUNT_LINK_RESOLVED = "count/link_resolved"
+const COUNT_LINK_UNRESOLVED = "count/link_unresolved"
+const COUNT_ATTACHMENT_CACHED = "count/attachment_cached"
+const COUNT_ATTACHMENT_WRITTEN = "count/attachment_written"
+const COUNT_ATTACHMENT_REMOVED = "count/attachment_removed"
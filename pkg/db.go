@@ -2,11 +2,13 @@ package diatom
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"path"
+	"sort"
 	"strings"
-
-	"gopkg.in/yaml.v2"
+	"time"
 )
 
 /*
@@ -25,479 +27,1628 @@ func (conn *ObsidianDB) Close() error {
 }
 
 /*
- * Create tables
+ * Get the stored hash of every file, keyed by vault-relative path
  */
-func (conn *ObsidianDB) CreateTables() error {
-	// create a file table
-	tx, err := conn.Db.Begin()
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec(`create table if not exists file (
-		id         text not null,
-		basename   text not null,
-		title      text not null,
-		hash       text not null,
-		in_degree  integer default 0    check(in_degree  >= 0),
-		out_degree integer default 0    check(out_degree >= 0),
-
-		primary key(id)
-	)`)
-
-	if err != nil {
-		return err
-	}
-
-	// create a tag table
-	_, err = tx.Exec(`create table if not exists tag (
-		tag      text not null,
-		file_id  text not null,
-
-		primary key(tag, file_id)
-	)`)
+func (conn *ObsidianDB) GetFileHashes() (map[string]string, error) {
+	hashes := map[string]string{}
 
+	rows, err := conn.Db.Query(`select path, hash from file`)
 	if err != nil {
-		return err
+		return hashes, err
 	}
+	defer rows.Close()
 
-	// create a url table
-	_, err = tx.Exec(`create table if not exists url (
-		url      text not null,
-		file_id  text not null,
+	for rows.Next() {
+		var fpath, hash string
 
-		primary key(url, file_id)
-	)`)
+		if err := rows.Scan(&fpath, &hash); err != nil {
+			return hashes, err
+		}
 
-	if err != nil {
-		return err
+		hashes[fpath] = hash
 	}
 
-	_, err = tx.Exec(`create table if not exists wikilink (
-		reference text not null,
-		alias    text,
-		file_id  text not null,
+	return hashes, rows.Err()
+}
 
-		primary key(reference, alias, file_id)
-	)`)
+/*
+ * Get the stored hash, mtime, size and staleness of every file, keyed by vault-relative path
+ */
+func (conn *ObsidianDB) GetFileStates() (map[string]FileState, error) {
+	states := map[string]FileState{}
 
+	rows, err := conn.Db.Query(`select path, hash, mtime, size, stale from file`)
 	if err != nil {
-		return err
+		return states, err
 	}
+	defer rows.Close()
 
-	_, err = tx.Exec(`create table if not exists metadata (
-		file_id  text not null,
-		schema   text not null,
-		content  text not null,
+	for rows.Next() {
+		var fpath string
+		var state FileState
 
-		primary key(file_id, content)
-	)`)
+		if err := rows.Scan(&fpath, &state.Hash, &state.Mtime, &state.Size, &state.Stale); err != nil {
+			return states, err
+		}
 
-	if err != nil {
-		return err
+		states[fpath] = state
 	}
 
-	_, err = tx.Exec(`create table if not exists heading (
-		heading  text not null,
-		level    integer not null,
-		file_id  text not null,
-
-		primary key(heading, level, file_id)
-	)`)
+	return states, rows.Err()
+}
 
+/*
+ * Get the frontmatter `id` of every file that has one, keyed by vault-relative path
+ */
+func (conn *ObsidianDB) GetFrontmatterIds() (map[string]string, error) {
+	ids := map[string]string{}
+
+	// the bundled sqlite is built without JSON1, so decode frontmatter here
+	rows, err := conn.Db.Query(`
+		select file.path, metadata.content
+			from metadata
+		join file on file.id = metadata.file_id
+			where metadata.schema = '!frontmatter'
+	`)
 	if err != nil {
-		return err
+		return ids, err
 	}
+	defer rows.Close()
 
-	return tx.Commit()
-}
+	for rows.Next() {
+		var fpath, content string
 
-/*
- * Get the file-hash from the file table in Sqlite
- */
-func (conn *ObsidianDB) GetFileHash(fpath string) (string, error) {
-	var hash string
-	row := conn.Db.QueryRow(`SELECT hash FROM file WHERE file.id = ?`, fpath)
+		if err := rows.Scan(&fpath, &content); err != nil {
+			return ids, err
+		}
 
-	if row == nil {
-		return hash, nil
-	}
+		frontmatter := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(content), &frontmatter); err != nil {
+			continue
+		}
 
-	err := row.Scan(&hash)
-	if err != nil {
-		return "", nil
+		if id := frontmatterIdText(frontmatter["id"]); id != "" {
+			ids[fpath] = id
+		}
 	}
 
-	return hash, nil
+	return ids, rows.Err()
 }
 
 /*
- *
+ * Move a file to a new path, keeping its id and rows, and record the move
  */
-func (conn *ObsidianDB) InsertFile(fpath, title, hash string) error {
-	tx, err := conn.Db.Begin()
+func (conn *ObsidianDB) RenameFile(tx *sql.Tx, previousPath, fpath, reason string) error {
+	var fileId string
+	err := tx.QueryRow(`select id from file where path = ?`, previousPath).Scan(&fileId)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
-
-	basename := path.Base(fpath)
-	ext := path.Ext(basename)
 
-	idx := strings.LastIndex(basename, ext)
-	basename = basename[:idx]
+	if _, err := tx.Exec(`update file set path = ? where id = ?`, fpath, fileId); err != nil {
+		return err
+	}
 
-	// save file to table
 	_, err = tx.Exec(`
-	insert or ignore into file (id, basename, title, hash) values (?, ?, ?, ?)
-	on conflict (id)
-	do update set title = ?, basename = ?, hash = ?
-	`, fpath, basename, title, hash, title, basename, hash)
+	insert into file_history (file_id, previous_path, path, reason, moved_at) values (?, ?, ?, ?, ?)
+	`, fileId, previousPath, fpath, reason, time.Now().UTC().Format(time.RFC3339))
 
-	if err != nil {
-		return err
-	}
+	return err
+}
 
-	return tx.Commit()
+// Prepared statements used to write notes
+type Statements struct {
+	FileIdByPath     *sql.Stmt
+	FilePathById     *sql.Stmt
+	DeleteFile       *sql.Stmt
+	InsertFile       *sql.Stmt
+	UpdateStat       *sql.Stmt
+	InsertTag        *sql.Stmt
+	InsertUrl        *sql.Stmt
+	InsertWikilink   *sql.Stmt
+	InsertMetadata   *sql.Stmt
+	InsertHeading    *sql.Stmt
+	InsertBlock      *sql.Stmt
+	InsertSection    *sql.Stmt
+	InsertTask       *sql.Stmt
+	InsertField      *sql.Stmt
+	InsertProperty   *sql.Stmt
+	InsertText       *sql.Stmt
+	InsertDiagnostic *sql.Stmt
 }
 
 /*
- *
+ * Prepare the statements used to write notes
  */
-func (conn *ObsidianDB) InsertTags(bodyData *MarkdownData, fpath string) error {
-	tx, err := conn.Db.Begin()
-	if err != nil {
-		return err
+func (conn *ObsidianDB) PrepareStatements() (*Statements, error) {
+	stmts := &Statements{}
+
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&stmts.FileIdByPath, `select id from file where path = ?`},
+		{&stmts.FilePathById, `select path from file where id = ?`},
+		{&stmts.DeleteFile, `delete from file where id = ? or path = ?`},
+		{&stmts.InsertFile, `
+		insert into file (id, path, basename, title, hash, mtime, size) values (?, ?, ?, ?, ?, ?, ?)
+		on conflict (id)
+		do update set path = excluded.path, title = excluded.title, basename = excluded.basename,
+			hash = excluded.hash, mtime = excluded.mtime, size = excluded.size, stale = 0
+		`},
+		{&stmts.UpdateStat, `update file set mtime = ?, size = ? where path = ?`},
+		{&stmts.InsertTag, `
+		insert into tag (tag, file_id, task_id, start_offset, end_offset, line, column) values (?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertUrl, `
+		insert into url (url, scheme, host, path, text, file_id, start_offset, end_offset, line, column)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertWikilink, `
+		insert into wikilink (
+			reference, alias, file_id, target, heading, heading_slug, block_id, is_embed, type, task_id,
+			start_offset, end_offset, line, column)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertMetadata, `
+		insert into metadata (file_id, schema, content, start_offset, end_offset, line, column) values (?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertHeading, `
+		insert into heading (heading, level, slug, file_id, start_offset, end_offset, line, column)
+		values (?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertBlock, `
+		insert into block (file_id, block_id, type, text, start_offset, end_offset, line, column)
+		values (?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertSection, `
+		insert into section (file_id, section_id, parent_id, level, heading, text, start_offset, end_offset, line, column)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertTask, `
+		insert into task (
+			file_id, task_id, parent_id, status, text, priority, recurrence,
+			created, start, scheduled, due, cancelled, done, start_offset, end_offset, line, column)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertField, `
+		insert into field (file_id, source, key, value, type, section_id, task_id, start_offset, end_offset, line, column)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertProperty, `
+		insert into property (file_id, key, list_index, type, text, number, date, boolean, link)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&stmts.InsertDiagnostic, `
+		insert into diagnostic (file_id, line, column, severity, extractor, message) values (?, ?, ?, ?, ?, ?)
+		`},
 	}
-	defer tx.Rollback()
-
-	for _, tag := range bodyData.Tags {
-		_, err := tx.Exec(`insert or replace into tag (tag, file_id) values (?, ?)`, tag, fpath)
 
+	for _, query := range queries {
+		stmt, err := conn.Db.Prepare(query.query)
 		if err != nil {
-			return err
+			stmts.Close()
+			return nil, err
 		}
-	}
 
-	return tx.Commit()
-}
+		*query.stmt = stmt
+	}
 
-/*
- *
- */
-func (conn *ObsidianDB) InsertUrl(bodyData *MarkdownData, fpath string) error {
-	tx, err := conn.Db.Begin()
+	// a database without a full-text index stores notes without their text
+	fullText, err := conn.HasFullText()
 	if err != nil {
-		return err
+		stmts.Close()
+		return nil, err
 	}
-	defer tx.Rollback()
 
-	for _, url := range bodyData.Urls {
-		_, err := tx.Exec(`
-		insert or ignore into url (url, file_id) values (?, ?)
-		`, url, fpath)
+	if fullText {
+		stmts.InsertText, err = conn.Db.Prepare(`insert into note_text (file_id, title, headings, body) values (?, ?, ?, ?)`)
 		if err != nil {
-			return err
+			stmts.Close()
+			return nil, err
 		}
 	}
 
-	return tx.Commit()
+	return stmts, nil
 }
 
 /*
- *
+ * Close prepared statements
  */
-func (conn *ObsidianDB) InsertFrontmatter(frontmatter map[string]interface{}, fpath string) error {
-	tx, err := conn.Db.Begin()
-	if err != nil {
-		return err
+func (stmts *Statements) Close() {
+	for _, stmt := range []*sql.Stmt{
+		stmts.FileIdByPath, stmts.FilePathById, stmts.DeleteFile, stmts.InsertFile, stmts.UpdateStat, stmts.InsertTag, stmts.InsertUrl,
+		stmts.InsertWikilink, stmts.InsertMetadata, stmts.InsertHeading, stmts.InsertBlock, stmts.InsertSection, stmts.InsertTask,
+		stmts.InsertField, stmts.InsertProperty, stmts.InsertText,
+		stmts.InsertDiagnostic,
+	} {
+		if stmt != nil {
+			stmt.Close()
+		}
 	}
-	defer tx.Rollback()
+}
 
-	bytes, err := yaml.Marshal(frontmatter)
-	if err != nil {
-		return err
-	}
+/*
+ * Choose a note's id: its frontmatter `id` unless another note already has
+ * it, then the id already stored for its path, then one generated from its path.
+ * A frontmatter id shared by several notes is given to one of them afterwards,
+ * by AssignFrontmatterIds.
+ */
+func (stmts *Statements) NoteId(tx *sql.Tx, result *NoteResult) (string, error) {
+	if result.FrontmatterId != "" {
+		var owner string
+		err := tx.Stmt(stmts.FilePathById).QueryRow(result.FrontmatterId).Scan(&owner)
 
-	json, err := yamlToJson(string(bytes))
-	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && owner == result.Path) {
+			return result.FrontmatterId, nil
+		}
+		if err != nil {
+			return "", err
+		}
 	}
 
-	_, err = tx.Exec(`
-	insert or replace into metadata (file_id, schema, content) values (?, ?, ?)
-	`, fpath, "!frontmatter", json)
+	var fileId string
+	err := tx.Stmt(stmts.FileIdByPath).QueryRow(result.Path).Scan(&fileId)
 
-	if err != nil {
-		return err
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fileId, err
 	}
 
-	return tx.Commit()
+	return unusedNoteId(tx, result.Path)
 }
 
 /*
- *
+ * Generate an id for a path that no note has yet; a note renamed away keeps
+ * the id generated for its old path, so later attempts add a suffix
  */
-func (conn *ObsidianDB) InsertWikilinks(bodyData *MarkdownData, fpath string) error {
-	tx, err := conn.Db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+func unusedNoteId(tx *sql.Tx, rel string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		name := rel
+		if attempt > 0 {
+			name = fmt.Sprintf("%v#%v", rel, attempt)
+		}
 
-	for _, wikilink := range bodyData.Wikilinks {
-		_, err := tx.Exec(`
-		insert or ignore into wikilink (reference, alias, file_id) values (?, ?, ?)
-		`, wikilink.Reference, wikilink.Alias, fpath)
+		fileId := GenerateNoteId(name)
+		err := tx.QueryRow(`select path from file where id = ?`, fileId).Scan(new(string))
 
+		if errors.Is(err, sql.ErrNoRows) {
+			return fileId, nil
+		}
 		if err != nil {
-			return err
+			return "", err
 		}
 	}
-
-	return tx.Commit()
 }
 
 /*
- *
+ * Change a file's id, along with every row referring to it. Foreign keys are
+ * checked when the transaction commits, once the rows agree again.
  */
-func (conn *ObsidianDB) GetInDegree() (*sql.Rows, error) {
-	return conn.Db.Query(`
-		select count(reference) as in_degree, id
-				from file
-			left join wikilink on file.basename = wikilink.reference
-				where id is not null
-			group by id
-		order by in_degree desc`)
-}
+func rekeyFile(tx *sql.Tx, fromId, toId string, fullText bool) error {
+	if _, err := tx.Exec(`pragma defer_foreign_keys = on`); err != nil {
+		return err
+	}
 
-func (conn *ObsidianDB) GetOutDegree() (*sql.Rows, error) {
-	return conn.Db.Query(`
-		select count(*) as out_degree, file_id as id
-			from wikilink
-		group by file_id
-		order by out_degree desc
+	rows, err := tx.Query(`
+	select distinct master.name
+		from sqlite_master as master
+	join pragma_foreign_key_list(master.name) as foreign_key
+		where master.type = 'table' and foreign_key."table" = 'file'
 	`)
-}
-
-/*
- * Insert a degree row into the database
- */
-func (conn *ObsidianDB) InsertInDegree(rows *sql.Rows) error {
-	tx, err := conn.Db.Begin()
 	if err != nil {
 		return err
 	}
 
-	file := struct {
-		in_degree int
-		id        string
-	}{}
+	updates := []string{
+		`update file set id = ? where id = ?`,
+		`update file_history set file_id = ? where file_id = ?`,
+		`update wikilink set resolved_file_id = ? where resolved_file_id = ?`,
+	}
+	for rows.Next() {
+		var table string
 
-	err = rows.Scan(&file.in_degree, &file.id)
-	if err != nil {
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		updates = append(updates, fmt.Sprintf(`update %v set file_id = ? where file_id = ?`, table))
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(`
-	update file
-	set in_degree = ?
-	where id = ?
-	`, file.in_degree, file.id)
+	if fullText {
+		updates = append(updates, `update note_text set file_id = ? where file_id = ?`)
+	}
 
-	if err != nil {
-		return err
+	for _, update := range updates {
+		if _, err := tx.Exec(update, toId, fromId); err != nil {
+			return err
+		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
+// The diagnostic given to notes sharing another note's frontmatter id, and a pattern matching it
+const DUPLICATE_ID_MESSAGE = "frontmatter id %v is also used by %v, which keeps it"
+const DUPLICATE_ID_PATTERN = "frontmatter id % is also used by %, which keeps it"
+
 /*
- *
+ * Give each frontmatter id to the note with the lexically smallest path among
+ * those declaring it, so the owner does not depend on the order notes were
+ * written in. Other notes declaring it keep ids generated from their paths, and
+ * have a diagnostic naming the owner.
  */
-func (conn *ObsidianDB) InsertOutDegree(rows *sql.Rows) error {
-	tx, err := conn.Db.Begin()
+func (conn *ObsidianDB) AssignFrontmatterIds() error {
+	declared, err := conn.GetFrontmatterIds()
 	if err != nil {
 		return err
 	}
 
-	file := struct {
-		out_degree int
-		id         string
-	}{}
-
-	err = rows.Scan(&file.out_degree, &file.id)
+	fullText, err := conn.HasFullText()
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(`
-	update file
-	set out_degree = ?
-	where id = ?
-	`, file.out_degree, file.id)
+	paths := map[string][]string{}
+	for fpath, id := range declared {
+		paths[id] = append(paths[id], fpath)
+	}
 
+	tx, err := conn.Db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return tx.Commit()
-}
-
-/*
- *
- */
-func (conn *ObsidianDB) AddInDegree() error {
-	rows, err := conn.GetInDegree()
+	_, err = tx.Exec(`delete from diagnostic where extractor = ? and message like ?`, EXTRACTOR_FRONTMATTER, DUPLICATE_ID_PATTERN)
 	if err != nil {
 		return err
 	}
 
-	count := 0
+	for id, declaring := range paths {
+		sort.Strings(declaring)
+		owner := declaring[0]
 
-	for rows.Next() {
-		count++
-		if err = conn.InsertInDegree(rows); err != nil {
+		var ownerId string
+		if err := tx.QueryRow(`select id from file where path = ?`, owner).Scan(&ownerId); err != nil {
 			return err
 		}
-	}
 
-	if count == 0 {
-		return errors.New("no files present in database")
-	}
+		if ownerId != id {
+			// another note has the id, because it was written first or kept the id it once declared
+			var holder string
+			err := tx.QueryRow(`select path from file where id = ?`, id).Scan(&holder)
+
+			if err == nil {
+				holderId, err := unusedNoteId(tx, holder)
+				if err != nil {
+					return err
+				}
+				if err := rekeyFile(tx, id, holderId, fullText); err != nil {
+					return err
+				}
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+
+			if err := rekeyFile(tx, ownerId, id, fullText); err != nil {
+				return err
+			}
+		}
 
-	err = rows.Close()
-	if err != nil {
-		return err
+		for _, fpath := range declaring[1:] {
+			_, err := tx.Exec(`
+			insert into diagnostic (file_id, line, column, severity, extractor, message)
+				select id, 1, 0, ?, ?, ? from file where path = ?
+			`, SEVERITY_WARNING, EXTRACTOR_FRONTMATTER, fmt.Sprintf(DUPLICATE_ID_MESSAGE, id, owner), fpath)
+
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 /*
- *
+ * Replace the stored data for a note, within a transaction
  */
-func (conn *ObsidianDB) AddOutDegree() error {
-	rows, err := conn.GetOutDegree()
+func (stmts *Statements) WriteNote(tx *sql.Tx, result *NoteResult) error {
+	data := result.Data
+
+	fileId, err := stmts.NoteId(tx, result)
 	if err != nil {
 		return err
 	}
 
-	if err != nil {
+	basename := path.Base(result.Path)
+	basename = strings.TrimSuffix(basename, path.Ext(basename))
+
+	// child rows cascade from the file; a note whose id changed loses its old row too
+	if _, err := tx.Stmt(stmts.DeleteFile).Exec(fileId, result.Path); err != nil {
 		return err
 	}
 
-	count := 0
+	if _, err := tx.Stmt(stmts.InsertFile).Exec(fileId, result.Path, basename, data.Title, data.Hash, result.Mtime, result.Size); err != nil {
+		return err
+	}
 
-	for rows.Next() {
-		count++
-		if err = conn.InsertOutDegree(rows); err != nil {
-			return err
+	// ids and dates are null when unset
+	nullable := func(value interface{}) interface{} {
+		if value == 0 || value == "" {
+			return nil
 		}
+		return value
 	}
 
-	if count == 0 {
-		return errors.New("no files present in database")
+	insertTag := tx.Stmt(stmts.InsertTag)
+	for _, tag := range data.Tags {
+		if _, err := insertTag.Exec(tag.Tag, fileId, nullable(tag.TaskId), tag.Start, tag.End, tag.Line, tag.Column); err != nil {
+			return err
+		}
 	}
 
-	err = rows.Close()
-	if err != nil {
-		return err
+	insertUrl := tx.Stmt(stmts.InsertUrl)
+	for _, url := range data.Urls {
+		_, err := insertUrl.Exec(
+			url.Url, url.Scheme, url.Host, url.Path, url.Text, fileId, url.Start, url.End, url.Line, url.Column)
+
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
+	insertWikilink := tx.Stmt(stmts.InsertWikilink)
+	for _, wikilink := range data.Wikilinks {
+		_, err := insertWikilink.Exec(
+			wikilink.Reference, wikilink.Alias, fileId, wikilink.Target,
+			wikilink.Heading, wikilink.HeadingSlug, wikilink.BlockId, wikilink.IsEmbed, wikilink.Type,
+			nullable(wikilink.TaskId), wikilink.Start, wikilink.End, wikilink.Line, wikilink.Column)
 
-/*
- * Insert metadata into a database
- */
-func (conn *ObsidianDB) InsertMetadata(tx *sql.Tx, fpath, info, yaml string) error {
-	_, err := tx.Exec(`
-	insert or ignore into metadata (file_id, schema, content) values (?, ?, ?)
-	`, fpath, info, yaml)
+		if err != nil {
+			return err
+		}
+	}
 
-	return err
-}
+	insertMetadata := tx.Stmt(stmts.InsertMetadata)
+	if data.Frontmatter != "" {
+		position := data.FrontmatterPosition
+		_, err := insertMetadata.Exec(
+			fileId, "!frontmatter", data.Frontmatter, position.Start, position.End, position.Line, position.Column)
 
-/*
- * Insert headings into sqlite
- */
-func (conn *ObsidianDB) InsertHeadings(bodyData *MarkdownData, fpath string) error {
-	tx, err := conn.Db.Begin()
-	if err != nil {
-		return err
+		if err != nil {
+			return err
+		}
 	}
-	defer tx.Rollback()
 
-	for _, heading := range bodyData.Headings {
-		_, err := tx.Exec(`insert or replace into heading (heading, level, file_id) values (?, ?, ?)`, heading.Text, heading.Level, fpath)
+	for _, metadata := range data.Metadata {
+		_, err := insertMetadata.Exec(
+			fileId, metadata.Schema, metadata.Content, metadata.Start, metadata.End, metadata.Line, metadata.Column)
 
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
-}
+	insertHeading := tx.Stmt(stmts.InsertHeading)
+	headings := []string{}
+	for _, heading := range data.Headings {
+		_, err := insertHeading.Exec(
+			heading.Text, heading.Level, heading.Slug, fileId, heading.Start, heading.End, heading.Line, heading.Column)
 
-/*
- *
- */
-func (conn *ObsidianDB) GetFileIds() ([]string, error) {
-	rows, err := conn.Db.Query(`select id from file`)
-	fileIds := []string{}
-	if err != nil {
-		return fileIds, err
+		if err != nil {
+			return err
+		}
+		headings = append(headings, heading.Text)
 	}
 
-	defer rows.Close()
+	insertBlock := tx.Stmt(stmts.InsertBlock)
+	for _, block := range data.Blocks {
+		_, err := insertBlock.Exec(fileId, block.Id, block.Type, block.Text, block.Start, block.End, block.Line, block.Column)
 
-	if err != nil {
-		return fileIds, err
+		if err != nil {
+			return err
+		}
 	}
 
-	for rows.Next() {
-		var fileId string
+	insertSection := tx.Stmt(stmts.InsertSection)
+	for _, section := range data.Sections {
+		_, err := insertSection.Exec(
+			fileId, section.Id, nullable(section.ParentId), section.Level, section.Heading, section.Text,
+			section.Start, section.End, section.Line, section.Column)
 
-		err := rows.Scan(&fileId)
 		if err != nil {
-			return fileIds, err
+			return err
 		}
+	}
 
-		fileIds = append(fileIds, fileId)
+	insertTask := tx.Stmt(stmts.InsertTask)
+	for _, task := range data.Tasks {
+		_, err := insertTask.Exec(
+			fileId, task.Id, nullable(task.ParentId), task.Status, task.Text, task.Priority, nullable(task.Recurrence),
+			nullable(task.CreatedDate), nullable(task.StartDate), nullable(task.ScheduledDate), nullable(task.DueDate),
+			nullable(task.CancelledDate), nullable(task.DoneDate), task.Start, task.End, task.Line, task.Column)
+
+		if err != nil {
+			return err
+		}
 	}
 
-	return fileIds, nil
-}
+	insertField := tx.Stmt(stmts.InsertField)
+	for _, field := range data.Fields {
+		_, err := insertField.Exec(
+			fileId, field.Source, field.Key, field.Value, field.Type, nullable(field.SectionId), nullable(field.TaskId),
+			field.Start, field.End, field.Line, field.Column)
 
-/*
- *
- */
-func (conn *ObsidianDB) DeleteWikilink(fpath string) error {
-	_, err := conn.Db.Exec(`delete from wikilink where file_id = ?`, fpath)
-	return err
+		if err != nil {
+			return err
+		}
+	}
+
+	insertProperty := tx.Stmt(stmts.InsertProperty)
+	for _, property := range data.Properties {
+		var index interface{}
+		if property.Index >= 0 {
+			index = property.Index
+		}
+
+		_, err := insertProperty.Exec(
+			fileId, property.Key, index, property.Type, nullable(property.Text), property.Number,
+			nullable(property.Date), property.Boolean, nullable(property.Link))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// note text is removed by a trigger when the file row is deleted
+	if data.Text != "" && stmts.InsertText != nil {
+		_, err := tx.Stmt(stmts.InsertText).Exec(fileId, data.Title, strings.Join(headings, "\n"), data.Text)
+		if err != nil {
+			return err
+		}
+	}
+
+	insertDiagnostic := tx.Stmt(stmts.InsertDiagnostic)
+	for _, diagnostic := range data.Diagnostics {
+		_, err := insertDiagnostic.Exec(
+			fileId, diagnostic.Line, diagnostic.Column, diagnostic.Severity, diagnostic.Extractor, diagnostic.Message)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Write a batch of notes in one transaction. Each note is written inside a
+ * savepoint, so a failing note is rolled back alone and the rest of the batch
+ * commits. Returns per-note failures, keyed by vault-relative path.
+ */
+func (conn *ObsidianDB) WriteNotes(stmts *Statements, results []*NoteResult) (map[string]error, error) {
+	failures := map[string]error{}
+
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return failures, err
+	}
+	defer tx.Rollback()
+
+	for _, result := range results {
+		if _, err := tx.Exec(`savepoint note`); err != nil {
+			return failures, err
+		}
+
+		err := error(nil)
+		if result.RenamedFrom != "" {
+			err = conn.RenameFile(tx, result.RenamedFrom, result.Path, result.RenameReason)
+		}
+
+		if err == nil && result.StatOnly {
+			_, err = tx.Stmt(stmts.UpdateStat).Exec(result.Mtime, result.Size, result.Path)
+		} else if err == nil {
+			err = stmts.WriteNote(tx, result)
+		}
+
+		if err != nil {
+			failures[result.Path] = err
+
+			if _, err := tx.Exec(`rollback to note`); err != nil {
+				return failures, err
+			}
+		}
+
+		if _, err := tx.Exec(`release note`); err != nil {
+			return failures, err
+		}
+	}
+
+	return failures, tx.Commit()
 }
 
 /*
  *
  */
-func (conn *ObsidianDB) DeleteTag(fpath string) error {
-	_, err := conn.Db.Exec(`delete from tag where file_id = ?`, fpath)
-	return err
+func (conn *ObsidianDB) GetInDegree() (*sql.Rows, error) {
+	return conn.Db.Query(`
+		select count(wikilink.file_id) as in_degree, id
+				from file
+			left join wikilink on file.id = wikilink.resolved_file_id
+				where id is not null
+			group by id
+		order by in_degree desc`)
+}
+
+/*
+ * Get the target of every wikilink, for link-resolution
+ */
+func (conn *ObsidianDB) GetWikilinkTargets() (*sql.Rows, error) {
+	return conn.Db.Query(`select rowid, target, file_id from wikilink`)
+}
+
+/*
+ * Get the target and current resolution of every wikilink
+ */
+func (conn *ObsidianDB) GetWikilinkResolutions() (*sql.Rows, error) {
+	return conn.Db.Query(`
+	select rowid, target, file_id, coalesce(resolved_file_id, ''), coalesce(resolved_attachment, '') from wikilink
+	`)
+}
+
+/*
+ * Get the notes the given files' wikilinks resolve to
+ */
+func (conn *ObsidianDB) GetLinkedFileIds(fileIds []string) ([]string, error) {
+	linked := []string{}
+
+	stmt, err := conn.Db.Prepare(`
+	select distinct resolved_file_id from wikilink where file_id = ? and resolved_file_id is not null
+	`)
+	if err != nil {
+		return linked, err
+	}
+	defer stmt.Close()
+
+	for _, fileId := range fileIds {
+		rows, err := stmt.Query(fileId)
+		if err != nil {
+			return linked, err
+		}
+
+		for rows.Next() {
+			var target string
+
+			if err := rows.Scan(&target); err != nil {
+				rows.Close()
+				return linked, err
+			}
+			linked = append(linked, target)
+		}
+
+		if err := rows.Close(); err != nil {
+			return linked, err
+		}
+	}
+
+	return linked, nil
+}
+
+/*
+ * Recompute in-degree and out-degree for the given files only
+ */
+func (conn *ObsidianDB) UpdateDegrees(fileIds []string) error {
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	update file
+	set in_degree  = (select count(*) from wikilink where wikilink.resolved_file_id = file.id),
+		out_degree = (select count(*) from wikilink where wikilink.file_id = file.id)
+	where id = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, fileId := range fileIds {
+		if _, err := stmt.Exec(fileId); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Record which note or attachment each wikilink resolves to; unresolved
+ * links have neither, and are flagged with is_resolved = 0
+ */
+func (conn *ObsidianDB) UpdateWikilinkResolutions(resolutions map[int64]Resolution) error {
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	update wikilink set resolved_file_id = ?, resolved_attachment = ?, is_resolved = ? where rowid = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	nullable := func(value string) interface{} {
+		if value == "" {
+			return nil
+		}
+		return value
+	}
+
+	for rowid, resolution := range resolutions {
+		_, err := stmt.Exec(nullable(resolution.FileId), nullable(resolution.Attachment), resolution.Resolved(), rowid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (conn *ObsidianDB) GetOutDegree() (*sql.Rows, error) {
+	return conn.Db.Query(`
+		select count(wikilink.file_id) as out_degree, id
+				from file
+			left join wikilink on file.id = wikilink.file_id
+			group by id
+		order by out_degree desc
+	`)
+}
+
+/*
+ * Insert a degree row into the database
+ */
+func (conn *ObsidianDB) InsertInDegree(rows *sql.Rows) error {
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	file := struct {
+		in_degree int
+		id        string
+	}{}
+
+	err = rows.Scan(&file.in_degree, &file.id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	update file
+	set in_degree = ?
+	where id = ?
+	`, file.in_degree, file.id)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 /*
  *
  */
-func (conn *ObsidianDB) DeleteMetadata(fpath string) error {
-	_, err := conn.Db.Exec(`delete from metadata where file_id = ?`, fpath)
-	return err
+func (conn *ObsidianDB) InsertOutDegree(rows *sql.Rows) error {
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	file := struct {
+		out_degree int
+		id         string
+	}{}
+
+	err = rows.Scan(&file.out_degree, &file.id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	update file
+	set out_degree = ?
+	where id = ?
+	`, file.out_degree, file.id)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 /*
  *
  */
-func (conn *ObsidianDB) DeleteFile(fpath string) error {
-	_, err := conn.Db.Exec(`delete from file where id = ?`, fpath)
-	return err
+func (conn *ObsidianDB) AddInDegree() error {
+	rows, err := conn.GetInDegree()
+	if err != nil {
+		return err
+	}
+
+	// an empty vault has no degrees to set
+	for rows.Next() {
+		if err = conn.InsertInDegree(rows); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/*
+ *
+ */
+func (conn *ObsidianDB) AddOutDegree() error {
+	rows, err := conn.GetOutDegree()
+	if err != nil {
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// an empty vault has no degrees to set
+	for rows.Next() {
+		if err = conn.InsertOutDegree(rows); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Get the absolute path of the vault this database indexes; empty
+ * before the first run
+ */
+func (conn *ObsidianDB) GetVault() (string, error) {
+	var dpath string
+	err := conn.Db.QueryRow(`select path from vault where id = 1`).Scan(&dpath)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return dpath, err
+}
+
+/*
+ * Record the absolute path of the vault this database indexes
+ */
+func (conn *ObsidianDB) SetVault(dpath string) error {
+	_, err := conn.Db.Exec(`
+	insert into vault (id, path) values (1, ?)
+	on conflict(id) do update set path = excluded.path
+	`, dpath)
+
+	return err
+}
+
+/*
+ * Get the vault-relative path of every file, keyed by file id
+ */
+func (conn *ObsidianDB) GetFilePaths() (map[string]string, error) {
+	paths := map[string]string{}
+
+	rows, err := conn.Db.Query(`select id, path from file`)
+	if err != nil {
+		return paths, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fileId, fpath string
+
+		if err := rows.Scan(&fileId, &fpath); err != nil {
+			return paths, err
+		}
+
+		paths[fileId] = fpath
+	}
+
+	return paths, rows.Err()
+}
+
+/*
+ * Delete a file; rows in child tables cascade
+ */
+func (conn *ObsidianDB) DeleteFile(fileId string) error {
+	_, err := conn.Db.Exec(`delete from file where id = ?`, fileId)
+	return err
+}
+
+/*
+ * Delete the file stored under a vault-relative path
+ */
+func (conn *ObsidianDB) DeletePath(fpath string) error {
+	_, err := conn.Db.Exec(`delete from file where path = ?`, fpath)
+	return err
+}
+
+/*
+ * Get the stored hash, mtime and size of every attachment, keyed by vault-relative path
+ */
+func (conn *ObsidianDB) GetAttachmentStates() (map[string]FileState, error) {
+	states := map[string]FileState{}
+
+	rows, err := conn.Db.Query(`select path, hash, mtime, size from attachment`)
+	if err != nil {
+		return states, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fpath string
+		var state FileState
+
+		if err := rows.Scan(&fpath, &state.Hash, &state.Mtime, &state.Size); err != nil {
+			return states, err
+		}
+
+		states[fpath] = state
+	}
+
+	return states, rows.Err()
+}
+
+/*
+ * Get the vault-relative path of every attachment
+ */
+func (conn *ObsidianDB) GetAttachmentPaths() ([]string, error) {
+	paths := []string{}
+
+	rows, err := conn.Db.Query(`select path from attachment`)
+	if err != nil {
+		return paths, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fpath string
+		if err := rows.Scan(&fpath); err != nil {
+			return paths, err
+		}
+
+		paths = append(paths, fpath)
+	}
+
+	return paths, rows.Err()
+}
+
+/*
+ * Insert or replace attachments, in one transaction
+ */
+func (conn *ObsidianDB) WriteAttachments(attachments []*Attachment) error {
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	insert into attachment (path, extension, mime_type, size, mtime, hash) values (?, ?, ?, ?, ?, ?)
+	on conflict (path)
+	do update set extension = excluded.extension, mime_type = excluded.mime_type,
+		size = excluded.size, mtime = excluded.mtime, hash = excluded.hash
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, attachment := range attachments {
+		_, err := stmt.Exec(
+			attachment.Path, attachment.Extension, attachment.MimeType, attachment.Size, attachment.Mtime, attachment.Hash)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Delete attachments by vault-relative path, in one transaction
+ */
+func (conn *ObsidianDB) DeleteAttachments(paths []string) error {
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, fpath := range paths {
+		if _, err := tx.Exec(`delete from attachment where path = ?`, fpath); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Get problems recorded while extracting notes
+ */
+func (conn *ObsidianDB) GetDiagnostics() ([]Problem, error) {
+	rows, err := conn.Db.Query(`
+		select file.path, diagnostic.line, diagnostic.column, diagnostic.severity, diagnostic.extractor, diagnostic.message
+			from diagnostic
+		join file on file.id = diagnostic.file_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []Problem{}
+	for rows.Next() {
+		var problem Problem
+		var extractor string
+
+		err := rows.Scan(&problem.File, &problem.Line, &problem.Column, &problem.Severity, &extractor, &problem.Message)
+		if err != nil {
+			return problems, err
+		}
+
+		problem.Kind = PROBLEM_INVALID + extractor
+		problems = append(problems, problem)
+	}
+
+	return problems, rows.Err()
+}
+
+/*
+ * Get wikilinks that do not resolve to a note
+ */
+func (conn *ObsidianDB) GetBrokenLinks() ([]Problem, error) {
+	rows, err := conn.Db.Query(`
+		select file.path, wikilink.line, wikilink.column, wikilink.reference
+			from wikilink
+		join file on file.id = wikilink.file_id
+			where wikilink.is_resolved = 0 and wikilink.type = 'link'
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []Problem{}
+	for rows.Next() {
+		var fpath, reference string
+		var line, column int
+
+		if err := rows.Scan(&fpath, &line, &column, &reference); err != nil {
+			return problems, err
+		}
+
+		problems = append(problems, Problem{
+			File:     fpath,
+			Line:     line,
+			Column:   column,
+			Severity: SEVERITY_ERROR,
+			Kind:     PROBLEM_BROKEN_LINK,
+			Message:  "[[" + reference + "]] does not resolve to a note",
+		})
+	}
+
+	return problems, rows.Err()
+}
+
+/*
+ * Get embeds that do not resolve to a note or attachment
+ */
+func (conn *ObsidianDB) GetBrokenEmbeds() ([]Problem, error) {
+	rows, err := conn.Db.Query(`
+		select file.path, wikilink.line, wikilink.column, wikilink.reference
+			from wikilink
+		join file on file.id = wikilink.file_id
+			where wikilink.is_resolved = 0 and wikilink.type != 'link'
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []Problem{}
+	for rows.Next() {
+		var fpath, reference string
+		var line, column int
+
+		if err := rows.Scan(&fpath, &line, &column, &reference); err != nil {
+			return problems, err
+		}
+
+		problems = append(problems, Problem{
+			File:     fpath,
+			Line:     line,
+			Column:   column,
+			Severity: SEVERITY_ERROR,
+			Kind:     PROBLEM_BROKEN_EMBED,
+			Message:  "![[" + reference + "]] does not resolve to a note or attachment",
+		})
+	}
+
+	return problems, rows.Err()
+}
+
+/*
+ * Get attachments that no wikilink resolves to
+ */
+func (conn *ObsidianDB) GetUnusedAttachments() ([]Problem, error) {
+	rows, err := conn.Db.Query(`
+		select path from attachment
+			where not exists (
+				select 1 from wikilink where wikilink.resolved_attachment = attachment.path
+			)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []Problem{}
+	for rows.Next() {
+		var fpath string
+
+		if err := rows.Scan(&fpath); err != nil {
+			return problems, err
+		}
+
+		problems = append(problems, Problem{
+			File:     fpath,
+			Severity: SEVERITY_WARNING,
+			Kind:     PROBLEM_UNUSED_ATTACHMENT,
+			Message:  "attachment is not linked or embedded by any note",
+		})
+	}
+
+	return problems, rows.Err()
+}
+
+/*
+ * Get wikilinks to a heading missing from the linked note
+ */
+func (conn *ObsidianDB) GetBrokenHeadingLinks() ([]Problem, error) {
+	rows, err := conn.Db.Query(`
+		select file.path, wikilink.line, wikilink.column, wikilink.reference, wikilink.heading
+			from wikilink
+		join file on file.id = wikilink.file_id
+			where wikilink.resolved_file_id is not null
+			and coalesce(wikilink.heading, '') != ''
+			and not exists (
+				select 1 from heading
+				where heading.file_id = wikilink.resolved_file_id
+					and heading.slug = wikilink.heading_slug
+			)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []Problem{}
+	for rows.Next() {
+		var fpath, reference, heading string
+		var line, column int
+
+		if err := rows.Scan(&fpath, &line, &column, &reference, &heading); err != nil {
+			return problems, err
+		}
+
+		problems = append(problems, Problem{
+			File:     fpath,
+			Line:     line,
+			Column:   column,
+			Severity: SEVERITY_ERROR,
+			Kind:     PROBLEM_BROKEN_HEADING,
+			Message:  "[[" + reference + "]] links to missing heading \"" + heading + "\"",
+		})
+	}
+
+	return problems, rows.Err()
+}
+
+/*
+ * Get wikilinks to a ^block-id missing from the linked note
+ */
+func (conn *ObsidianDB) GetBrokenBlockLinks() ([]Problem, error) {
+	rows, err := conn.Db.Query(`
+		select file.path, wikilink.line, wikilink.column, wikilink.reference, wikilink.block_id
+			from wikilink
+		join file on file.id = wikilink.file_id
+			where wikilink.resolved_file_id is not null
+			and coalesce(wikilink.block_id, '') != ''
+			and not exists (
+				select 1 from block
+				where block.file_id = wikilink.resolved_file_id
+					and lower(block.block_id) = lower(wikilink.block_id)
+			)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []Problem{}
+	for rows.Next() {
+		var fpath, reference, blockId string
+		var line, column int
+
+		if err := rows.Scan(&fpath, &line, &column, &reference, &blockId); err != nil {
+			return problems, err
+		}
+
+		problems = append(problems, Problem{
+			File:     fpath,
+			Line:     line,
+			Column:   column,
+			Severity: SEVERITY_ERROR,
+			Kind:     PROBLEM_BROKEN_BLOCK,
+			Message:  "[[" + reference + "]] links to missing block ^" + blockId,
+		})
+	}
+
+	return problems, rows.Err()
+}
+
+/*
+ * Get notes with no links to or from other notes; a note linking only
+ * to itself is still an orphan
+ */
+func (conn *ObsidianDB) GetOrphans() ([]Problem, error) {
+	rows, err := conn.Db.Query(`
+		select path
+			from file
+		where not exists (
+			select 1 from wikilink
+				where wikilink.file_id = file.id
+				and (wikilink.resolved_file_id is null or wikilink.resolved_file_id != file.id)
+		)
+		and not exists (
+			select 1 from wikilink
+				where wikilink.resolved_file_id = file.id and wikilink.file_id != file.id
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []Problem{}
+	for rows.Next() {
+		var fpath string
+
+		if err := rows.Scan(&fpath); err != nil {
+			return problems, err
+		}
+
+		problems = append(problems, Problem{
+			File:     fpath,
+			Severity: SEVERITY_WARNING,
+			Kind:     PROBLEM_ORPHAN,
+			Message:  "note has no links to or from other notes",
+		})
+	}
+
+	return problems, rows.Err()
+}
+
+/*
+ * Full-text search over note titles, headings and text. Lower bm25 scores
+ * rank higher; titles and headings are weighted above body text
+ */
+func (conn *ObsidianDB) SearchNotes(query, open, close string, limit int) ([]SearchResult, error) {
+	rows, err := conn.Db.Query(`
+		select file.path, file.title, bm25(note_text, 0.0, 10.0, 5.0, 1.0) as rank,
+			snippet(note_text, -1, ?, ?, '…', 16)
+			from note_text
+		join file on file.id = note_text.file_id
+			where note_text match ?
+		order by rank
+		limit ?
+	`, open, close, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+
+		if err := rows.Scan(&result.File, &result.Title, &result.Rank, &result.Snippet); err != nil {
+			return results, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+/*
+ * Get tasks matching a filter, except for its paths, with their tags.
+ * Tag filters match nested tags too, so #work matches #work/meeting
+ */
+func (conn *ObsidianDB) GetTasks(filter TaskFilter) ([]TaskResult, error) {
+	conditions := []string{}
+	params := []interface{}{}
+
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, "task.status in (?"+strings.Repeat(", ?", len(filter.Statuses)-1)+")")
+		for _, status := range filter.Statuses {
+			params = append(params, status)
+		}
+	}
+
+	if len(filter.Tags) > 0 {
+		matches := []string{}
+		for _, tag := range filter.Tags {
+			tag = "#" + strings.TrimPrefix(tag, "#")
+			matches = append(matches, "lower(tag.tag) = lower(?) or substr(lower(tag.tag), 1, length(?) + 1) = lower(?) || '/'")
+			params = append(params, tag, tag, tag)
+		}
+
+		conditions = append(conditions, `exists (
+			select 1 from tag
+			where tag.file_id = task.file_id and tag.task_id = task.task_id and (`+strings.Join(matches, " or ")+`))`)
+	}
+
+	// a validated filter's date is one of TASK_DATES, each the name of a column
+	if filter.From != "" || filter.To != "" {
+		date := filter.Date
+
+		if filter.From != "" {
+			conditions = append(conditions, "task."+date+" >= ?")
+			params = append(params, filter.From)
+		}
+		if filter.To != "" {
+			conditions = append(conditions, "task."+date+" <= ?")
+			params = append(params, filter.To)
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "where " + strings.Join(conditions, " and ")
+	}
+
+	rows, err := conn.Db.Query(`
+		select file.path, task.line, task.status, task.text, task.priority, coalesce(task.recurrence, ''),
+			coalesce(task.created, ''), coalesce(task.start, ''), coalesce(task.scheduled, ''),
+			coalesce(task.due, ''), coalesce(task.cancelled, ''), coalesce(task.done, ''),
+			coalesce((
+				select group_concat(tag.tag, ' ') from tag
+				where tag.file_id = task.file_id and tag.task_id = task.task_id
+			), '')
+			from task
+		join file on file.id = task.file_id
+		`+where+`
+		order by file.path, task.task_id
+	`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []TaskResult{}
+	for rows.Next() {
+		var result TaskResult
+		var tags string
+
+		err := rows.Scan(
+			&result.File, &result.Line, &result.Status, &result.Text, &result.Priority, &result.Recurrence,
+			&result.Created, &result.Start, &result.Scheduled, &result.Due, &result.Cancelled, &result.Done, &tags)
+		if err != nil {
+			return results, err
+		}
+
+		result.Tags = strings.Fields(tags)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+/*
+ * Get the property types last read from .obsidian/types.json
+ */
+func (conn *ObsidianDB) GetPropertyTypes() (map[string]string, error) {
+	rows, err := conn.Db.Query(`select key, type from property_type`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := map[string]string{}
+	for rows.Next() {
+		var key, propertyType string
+
+		if err := rows.Scan(&key, &propertyType); err != nil {
+			return types, err
+		}
+		types[key] = propertyType
+	}
+
+	return types, rows.Err()
+}
+
+/*
+ * Store the declared property types. When they change, notes with frontmatter
+ * are marked stale, so the next run reads them again. Their hashes are kept, so
+ * renamed notes are still matched by content.
+ */
+func (conn *ObsidianDB) UpdatePropertyTypes(types map[string]string) error {
+	stored, err := conn.GetPropertyTypes()
+	if err != nil {
+		return err
+	}
+
+	same := len(stored) == len(types)
+	for key, propertyType := range types {
+		same = same && stored[key] == propertyType
+	}
+
+	if same {
+		return nil
+	}
+
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`delete from property_type`); err != nil {
+		return err
+	}
+
+	for key, propertyType := range types {
+		if _, err := tx.Exec(`insert into property_type (key, type) values (?, ?)`, key, propertyType); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+	update file set stale = 1
+		where id in (select file_id from metadata where schema = '!frontmatter')
+	`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Get the extractors notes were last read with
+ */
+func (conn *ObsidianDB) GetExtractors() (map[string]bool, error) {
+	rows, err := conn.Db.Query(`select name from extractor`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	extractors := map[string]bool{}
+	for rows.Next() {
+		var name string
+
+		if err := rows.Scan(&name); err != nil {
+			return extractors, err
+		}
+		extractors[name] = true
+	}
+
+	return extractors, rows.Err()
+}
+
+/*
+ * Store the enabled extractors. When they change, every note is marked stale,
+ * so the next run reads it again: newly enabled extractors fill their tables,
+ * and rows from disabled extractors are removed.
+ */
+func (conn *ObsidianDB) UpdateExtractors(extractors []string) error {
+	stored, err := conn.GetExtractors()
+	if err != nil {
+		return err
+	}
+
+	enabled := map[string]bool{}
+	for _, name := range extractors {
+		enabled[name] = true
+	}
+
+	same := len(stored) == len(enabled)
+	for name := range enabled {
+		same = same && stored[name]
+	}
+
+	if same {
+		return nil
+	}
+
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`delete from extractor`); err != nil {
+		return err
+	}
+
+	for name := range enabled {
+		if _, err := tx.Exec(`insert into extractor (name) values (?)`, name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`update file set stale = 1`); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Get the vault-relative path of every stale note
+ */
+func (conn *ObsidianDB) GetStalePaths() ([]string, error) {
+	rows, err := conn.Db.Query(`select path from file where stale = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var fpath string
+
+		if err := rows.Scan(&fpath); err != nil {
+			return paths, err
+		}
+		paths = append(paths, fpath)
+	}
+
+	return paths, rows.Err()
 }
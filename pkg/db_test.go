@@ -0,0 +1,274 @@
+package diatom
+
+import (
+	"regexp"
+	"testing"
+)
+
+/*
+ * Read a note as the extract workers do, into a result ready to write
+ *
+ */
+func noteResult(t *testing.T, rel, text string) *NoteResult {
+	t.Helper()
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	note := NewNote(rel)
+	if err := note.ExtractData(text, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := note.Walk(cfg); err != nil {
+		t.Fatal(err)
+	}
+	note.ReadProperties(nil)
+
+	return note.Result(rel)
+}
+
+/*
+ * Write results in one batch, failing the test if the batch fails
+ *
+ */
+func writeResults(t *testing.T, conn *ObsidianDB, results ...*NoteResult) map[string]error {
+	t.Helper()
+
+	stmts, err := conn.PrepareStatements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmts.Close()
+
+	failures, err := conn.WriteNotes(stmts, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return failures
+}
+
+/*
+ * Get the id of every stored file, keyed by path
+ *
+ */
+func storedIds(t *testing.T, conn *ObsidianDB) map[string]string {
+	t.Helper()
+
+	paths, err := conn.GetFilePaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]string{}
+	for fileId, rel := range paths {
+		ids[rel] = fileId
+	}
+
+	return ids
+}
+
+func TestGenerateNoteId(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	id := GenerateNoteId("dir/a.md")
+	if !uuid.MatchString(id) {
+		t.Errorf("GenerateNoteId() = %q; want a version 5 UUID", id)
+	}
+	if again := GenerateNoteId("dir/a.md"); again != id {
+		t.Errorf("GenerateNoteId() = %q, then %q; want the same id for the same path", id, again)
+	}
+	if other := GenerateNoteId("dir/b.md"); other == id {
+		t.Errorf("GenerateNoteId() of two paths = %q; want different ids", id)
+	}
+
+	// a note is stored under the id generated from its path, in any database
+	for run := 0; run < 2; run++ {
+		conn := openTestDB(t)
+		if err := conn.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+
+		writeResults(t, conn, noteResult(t, "dir/a.md", "text\n"))
+		if got := storedIds(t, conn)["dir/a.md"]; got != id {
+			t.Errorf("stored id = %q; want %q", got, id)
+		}
+	}
+}
+
+func TestNoteIdFrontmatter(t *testing.T) {
+	conn := openTestDB(t)
+	if err := conn.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeResults(t, conn, noteResult(t, "a.md", "---\nid: note-a\n---\ntext\n"), noteResult(t, "b.md", "---\nid: 42\n---\n"))
+
+	ids := storedIds(t, conn)
+	if ids["a.md"] != "note-a" || ids["b.md"] != "42" {
+		t.Errorf("stored ids = %v; want the frontmatter ids note-a and 42", ids)
+	}
+}
+
+func TestNoteIdKeptAcrossRename(t *testing.T) {
+	conn := openTestDB(t)
+	if err := conn.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeResults(t, conn, noteResult(t, "a.md", "text\n"))
+
+	renamed := noteResult(t, "dir/c.md", "text\n")
+	renamed.RenamedFrom = "a.md"
+	renamed.RenameReason = RENAME_BY_HASH
+	writeResults(t, conn, renamed)
+
+	// a new note at the old path cannot take the id the renamed note kept
+	writeResults(t, conn, noteResult(t, "a.md", "new\n"))
+
+	ids := storedIds(t, conn)
+	if ids["dir/c.md"] != GenerateNoteId("a.md") {
+		t.Errorf("renamed note has id %q; want %q, generated from its first path", ids["dir/c.md"], GenerateNoteId("a.md"))
+	}
+	if ids["a.md"] != GenerateNoteId("a.md#1") {
+		t.Errorf("new note has id %q; want %q", ids["a.md"], GenerateNoteId("a.md#1"))
+	}
+}
+
+func TestNoteIdCollisions(t *testing.T) {
+	// diagnostics naming the owner of a shared id, keyed by path
+	duplicates := func(conn *ObsidianDB) map[string]int {
+		rows, err := conn.Db.Query(`
+		select file.path, count(*) from diagnostic join file on file.id = diagnostic.file_id
+			where diagnostic.extractor = ? and diagnostic.message like ?
+		group by file.path
+		`, EXTRACTOR_FRONTMATTER, DUPLICATE_ID_PATTERN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+
+		counts := map[string]int{}
+		for rows.Next() {
+			var fpath string
+			var count int
+			if err := rows.Scan(&fpath, &count); err != nil {
+				t.Fatal(err)
+			}
+			counts[fpath] = count
+		}
+
+		return counts
+	}
+
+	// the same notes, written in either order
+	for _, order := range [][]string{{"a.md", "b.md"}, {"b.md", "a.md"}} {
+		conn := openTestDB(t)
+		if err := conn.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, rel := range order {
+			writeResults(t, conn, noteResult(t, rel, "---\nid: shared\n---\n#"+rel[:1]+"\n"))
+		}
+		if err := conn.AssignFrontmatterIds(); err != nil {
+			t.Fatal(err)
+		}
+
+		ids := storedIds(t, conn)
+		if ids["a.md"] != "shared" || ids["b.md"] != GenerateNoteId("b.md") {
+			t.Errorf("writing %v: ids = %v; want a.md to have the shared id", order, ids)
+		}
+		if got := duplicates(conn); len(got) != 1 || got["b.md"] != 1 {
+			t.Errorf("writing %v: duplicate id diagnostics = %v; want one for b.md", order, got)
+		}
+
+		// child rows follow their note's id
+		var tagged string
+		if err := conn.Db.QueryRow(`select file_id from tag where tag = '#a'`).Scan(&tagged); err != nil || tagged != "shared" {
+			t.Errorf("writing %v: #a belongs to %q, %v; want shared", order, tagged, err)
+		}
+
+		// once the owner no longer declares the id, the other note has it
+		writeResults(t, conn, noteResult(t, "a.md", "#a\n"))
+		if err := conn.AssignFrontmatterIds(); err != nil {
+			t.Fatal(err)
+		}
+
+		ids = storedIds(t, conn)
+		if ids["b.md"] != "shared" || ids["a.md"] != GenerateNoteId("a.md") {
+			t.Errorf("writing %v, then dropping a.md's id: ids = %v; want b.md to have the shared id", order, ids)
+		}
+		if got := duplicates(conn); len(got) != 0 {
+			t.Errorf("writing %v, then dropping a.md's id: duplicate id diagnostics = %v; want none", order, got)
+		}
+	}
+}
+
+func TestWriteNotesRollsBackFailedNote(t *testing.T) {
+	conn := openTestDB(t)
+	if err := conn.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	text := "---\ntitle: x\n---\n# H\n#tag [[other]] https://e.com field:: 1\n\n- [ ] task\n"
+	writeResults(t, conn, noteResult(t, "kept.md", "# Old\n#old\n"))
+
+	// a task priority failing its check is written after the note's other rows
+	failing := func(rel string) *NoteResult {
+		result := noteResult(t, rel, text)
+		result.Data.Tasks[0].Priority = "urgent"
+		return result
+	}
+
+	failures := writeResults(t, conn,
+		noteResult(t, "a.md", text), failing("new.md"), failing("kept.md"), noteResult(t, "b.md", text))
+
+	if len(failures) != 2 || failures["new.md"] == nil || failures["kept.md"] == nil {
+		t.Fatalf("WriteNotes() failures = %v; want new.md and kept.md", failures)
+	}
+
+	tables := []string{"tag", "url", "wikilink", "metadata", "heading", "section", "task", "field", "property", "diagnostic"}
+	count := func(table, rel string) int {
+		var count int
+		err := conn.Db.QueryRow(`
+		select count(*) from `+table+` where file_id in (select id from file where path = ?)
+		`, rel).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// notes either side of the failures commit in full
+	for _, rel := range []string{"a.md", "b.md"} {
+		for _, table := range []string{"tag", "url", "wikilink", "heading", "task", "field", "property"} {
+			if count(table, rel) == 0 {
+				t.Errorf("%v has no %v rows; want its rows committed", rel, table)
+			}
+		}
+	}
+
+	// a new note that fails leaves nothing behind
+	var files int
+	if err := conn.Db.QueryRow(`select count(*) from file where path = 'new.md'`).Scan(&files); err != nil || files != 0 {
+		t.Errorf("new.md has %v file rows, %v; want none", files, err)
+	}
+	var orphaned int
+	for _, table := range tables {
+		err := conn.Db.QueryRow(`select count(*) from ` + table + ` where file_id not in (select id from file)`).Scan(&orphaned)
+		if err != nil || orphaned != 0 {
+			t.Errorf("%v has %v rows without a file, %v; want none", table, orphaned, err)
+		}
+	}
+
+	// a stored note that fails to be rewritten keeps its last rows
+	var tag string
+	if err := conn.Db.QueryRow(`
+	select tag from tag join file on file.id = tag.file_id where file.path = 'kept.md'
+	`).Scan(&tag); err != nil || tag != "#old" || count("tag", "kept.md") != 1 || count("task", "kept.md") != 0 {
+		t.Errorf("kept.md has tag %q, %v; want only its earlier #old", tag, err)
+	}
+}
@@ -1,55 +1,289 @@
 package diatom
 
 import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 )
 
 /*
- * Main function. Read from Obsidian & save as structured data.
+ * Main function. Read from Obsidian & save as structured data. Each run is
+ * recorded in the database, along with its statistics. Notes that fail to
+ * index are skipped and returned together as a RunErrors once the run completes.
+ *
+ * Cancelling the context stops new notes being read; notes already extracted
+ * are written, with their links resolved, and the next run picks up the rest.
  *
  */
-func Diatom(args *DiatomArgs) error {
-	conn, err := NewDB(args.DBPath)
+func Diatom(ctx context.Context, args *DiatomArgs) (err error) {
+	cfg := args.Config
+	conn, err := NewDB(cfg.DBPath)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	if err := conn.CreateTables(); err != nil {
-		return errors.Wrap(err, "failure creating tables")
+	if err := conn.Migrate(); err != nil {
+		return errors.Wrap(err, "failure migrating database")
 	}
 
 	stats := NewStats()
+	run := NewRun(args.Dir, stats)
 
-	removeWorker := RemoveWorker{
-		Stats: stats,
+	defer func() {
+		run.FinishedAt = time.Now()
+		run.Error = err
+
+		if insertErr := conn.InsertRun(run); insertErr != nil && err == nil {
+			err = errors.Wrap(insertErr, "failure recording run")
+		}
+	}()
+
+	if err := ClaimVault(&conn, args.Dir); err != nil {
+		return err
 	}
-	removeWorker.Start(&conn)
 
-	// extract information for each note into the database
-	extractors := ExtractWorkers{
+	vault := NewVault(args.Dir, cfg)
+
+	removeWorker := RemoveWorker{
 		Stats: stats,
-		Count: WORKER_COUNT,
-		Jobs:  make(chan string, 0),
+		Vault: &vault,
 	}
 
-	vault := ObsidianVault{dpath: args.Dir}
-	mdFiles, err := vault.GetNotes()
+	removeStart := time.Now()
+	renames, err := removeWorker.Start(&conn)
 	if err != nil {
 		return err
 	}
+	stats.Time(PHASE_REMOVE, removeStart)
 
-	for err := range extractors.Start(&conn, mdFiles) {
+	// the walk stops when this run returns, even when indexing ends early with an error
+	walkCtx, cancelWalk := context.WithCancel(ctx)
+	defer cancelWalk()
+
+	mdFiles, walkErrors := vault.GetNotes(walkCtx)
+
+	// collect errors from the vault walk while notes stream to the extractors
+	type walkResult struct {
+		err    error
+		failed bool
+	}
+
+	// buffered, so the collector finishes even when indexing fails before it is read
+	walkDone := make(chan walkResult, 1)
+	go func() {
+		defer close(walkDone)
+
+		result := walkResult{}
+		for err := range walkErrors {
+			var symlinkErr *SymlinkError
+			var vaultErr *VaultError
+			var pathErr *fs.PathError
+
+			switch {
+			case errors.As(err, &symlinkErr):
+				stats.Add(COUNT_SYMLINK_SKIPPED)
+				fmt.Fprintf(os.Stderr, "diatom: %v\n", err)
+			case errors.As(err, &vaultErr):
+				if result.err == nil {
+					result.err = vaultErr
+				}
+			case errors.As(err, &pathErr):
+				// an unreadable folder or link skips the notes beneath it
+				result.failed = true
+				stats.Fail(&NoteError{vault.RelPath(pathErr.Path), FAILURE_FIND, pathErr.Err})
+			case result.err == nil:
+				result.err = errors.Wrap(err, "failure walking vault")
+			}
+		}
+
+		walkDone <- result
+	}()
+
+	extractStart := time.Now()
+	if err := IndexNotes(ctx, &conn, &vault, cfg, stats, renames, mdFiles); err != nil {
 		return err
 	}
+	stats.Time(PHASE_EXTRACT, extractStart)
 
-	graphers := GraphWorker{
-		Stats: stats,
+	walk := <-walkDone
+	if walk.err != nil {
+		return walk.err
+	}
+
+	// links are resolved and degrees counted over every note in the database, so
+	// this also runs for the notes written before an interruption
+	buildGraph := func() error {
+		graphStart := time.Now()
+
+		// notes sharing a frontmatter id are written in any order, so its owner is chosen once all are written
+		if err := conn.AssignFrontmatterIds(); err != nil {
+			return errors.Wrap(err, "failure assigning frontmatter ids")
+		}
+
+		resolvers := ResolveWorker{
+			Stats: stats,
+		}
+		if err := resolvers.Start(&conn); err != nil {
+			return err
+		}
+
+		graphers := GraphWorker{
+			Stats: stats,
+		}
+		if err := graphers.Start(&conn); err != nil {
+			return err
+		}
+
+		stats.Time(PHASE_GRAPH, graphStart)
+		return nil
+	}
+
+	// unclaimed notes may be renamed to notes not yet read, so are kept for the next run
+	if ctx.Err() != nil {
+		if err := buildGraph(); err != nil {
+			return err
+		}
+		return errors.Wrap(ctx.Err(), "indexing interrupted")
+	}
+
+	// notes that vanished and were not renamed are removed once extraction has claimed
+	// renames; notes beneath a folder that could not be read are kept until it is read
+	if !walk.failed {
+		removeStart = time.Now()
+		if err := removeWorker.Finish(&conn, renames); err != nil {
+			return err
+		}
+		stats.Time(PHASE_REMOVE, removeStart)
+	}
+
+	// attachments are indexed after notes, so links to them resolve in this run
+	attachmentStart := time.Now()
+	attachers := AttachmentWorker{
+		Stats:  stats,
+		Config: cfg,
+		Vault:  &vault,
+	}
+	if _, err := attachers.Start(ctx, &conn); err != nil {
+		return err
+	}
+	stats.Time(PHASE_ATTACHMENTS, attachmentStart)
+
+	if err := buildGraph(); err != nil {
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return errors.Wrap(ctx.Err(), "indexing interrupted")
+	}
+
+	return stats.Err()
+}
+
+/*
+ * Check that the database indexes this vault, as notes are stored by vault-relative
+ * path and notes missing from another vault would be removed. A database is claimed by
+ * the first vault indexed into it; when that vault no longer exists it is taken to have
+ * moved, and the database follows it.
+ *
+ */
+func ClaimVault(conn *ObsidianDB, dpath string) error {
+	if abs, err := filepath.Abs(dpath); err == nil {
+		dpath = abs
+	}
+
+	stored, err := conn.GetVault()
+	if err != nil {
+		return errors.Wrap(err, "failure reading vault")
+	}
+
+	if stored == dpath {
+		return nil
+	}
+
+	if stored != "" {
+		if info, err := os.Stat(stored); err == nil && info.IsDir() {
+			return fmt.Errorf("the database indexes the vault %v, not %v; pass --dbpath to index another vault", stored, dpath)
+		}
+		fmt.Fprintf(os.Stderr, "diatom: vault %v no longer exists; assuming it moved to %v\n", stored, dpath)
+	}
+
+	return errors.Wrap(conn.SetVault(dpath), "failure recording vault")
+}
+
+/*
+ * Extract each note, and write changed notes to the database with a single writer.
+ * New notes matching a rename candidate keep the vanished note's rows. Notes that
+ * fail are recorded in the stats; only errors fatal to the run are returned.
+ *
+ */
+func IndexNotes(ctx context.Context, conn *ObsidianDB, vault *ObsidianVault, cfg *DiatomConfig, stats *Stats, renames *RenameCandidates, mdFiles <-chan string) error {
+	// changed property types mark notes with frontmatter as changed, so read them first
+	types, err := vault.PropertyTypes()
+	if err != nil {
+		// an unreadable types.json keeps the types last read, rather than retyping every note
+		fmt.Fprintf(os.Stderr, "diatom: %v\n", err)
+		types, err = conn.GetPropertyTypes()
+	} else {
+		err = conn.UpdatePropertyTypes(types)
+	}
+
+	if err != nil {
+		for range mdFiles {
+		}
+		return errors.Wrap(err, "failure reading property types")
+	}
+
+	// notes read with other extractors are read again
+	if err := conn.UpdateExtractors(cfg.Extractors); err != nil {
+		for range mdFiles {
+		}
+		return errors.Wrap(err, "failure recording extractors")
+	}
+
+	files, err := conn.GetFileStates()
+	if err != nil {
+		// let the producer finish, so it is not left blocked
+		for range mdFiles {
+		}
+		return errors.Wrap(err, "failure reading file states")
+	}
+
+	extractors := ExtractWorkers{
+		Stats:         stats,
+		Config:        cfg,
+		Vault:         vault,
+		Files:         files,
+		Renames:       renames,
+		Jobs:          make(chan string, 0),
+		Results:       make(chan *NoteResult, cfg.Workers),
+		PropertyTypes: types,
+	}
+
+	writer := WriteWorker{
+		Stats:     stats,
+		BatchSize: WRITE_BATCH_SIZE,
+	}
+
+	// drain every error, so no worker is left blocked; report the first fatal one
+	var firstErr error
+	for err := range MergeErrors(extractors.Start(ctx, mdFiles), writer.Start(conn, extractors.Results)) {
+		var noteErr *NoteError
+
+		if errors.As(err, &noteErr) {
+			stats.Fail(noteErr)
+		} else if firstErr == nil {
+			firstErr = err
+		}
 	}
-	graphers.Start(&conn)
 
-	return nil
+	return firstErr
 }
 
 /*
@@ -0,0 +1,119 @@
+package diatom
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+ * Write notes into a new vault folder, creating the folders they are in
+ *
+ */
+func writeVault(t *testing.T, notes map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for rel, text := range notes {
+		fpath := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fpath, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+/*
+ * Index a vault into a database file, with the given extractors
+ *
+ */
+func indexVault(t *testing.T, dir, dbpath string, extractors []string) {
+	t.Helper()
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DBPath = dbpath
+	cfg.Workers = 2
+	cfg.Extractors = extractors
+
+	if err := Diatom(context.Background(), &DiatomArgs{Dir: dir, Config: cfg}); err != nil {
+		t.Fatalf("Diatom(): %v", err)
+	}
+}
+
+/*
+ * Count the rows of each table
+ *
+ */
+func countRows(t *testing.T, dbpath string, tables ...string) map[string]int {
+	t.Helper()
+
+	conn, err := NewDB(dbpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	counts := map[string]int{}
+	for _, table := range tables {
+		var count int
+		if err := conn.Db.QueryRow(`select count(*) from ` + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		counts[table] = count
+	}
+
+	return counts
+}
+
+func TestExtractorChangesRereadNotes(t *testing.T) {
+	dir := writeVault(t, map[string]string{
+		"a.md": "# A\n#tag [[b]] https://e.com\n\n- [ ] task\n",
+		"b.md": "# B\n",
+	})
+	dbpath := filepath.Join(t.TempDir(), "diatom.sqlite")
+
+	runs := []struct {
+		name       string
+		extractors []string
+		want       map[string]int
+	}{
+		{
+			name:       "every extractor",
+			extractors: EXTRACTORS,
+			want:       map[string]int{"tag": 1, "task": 1, "url": 1, "wikilink": 1, "heading": 2},
+		},
+		{
+			name:       "tags and tasks disabled",
+			extractors: []string{EXTRACTOR_URLS, EXTRACTOR_WIKILINKS, EXTRACTOR_HEADINGS},
+			want:       map[string]int{"tag": 0, "task": 0, "url": 1, "wikilink": 1, "heading": 2},
+		},
+		{
+			name:       "tags and tasks enabled again",
+			extractors: EXTRACTORS,
+			want:       map[string]int{"tag": 1, "task": 1, "url": 1, "wikilink": 1, "heading": 2},
+		},
+	}
+
+	// the notes are unchanged between runs, so only the extractors differ
+	for _, run := range runs {
+		indexVault(t, dir, dbpath, run.extractors)
+
+		got := countRows(t, dbpath, "tag", "task", "url", "wikilink", "heading", "file where stale = 1")
+		for table, want := range run.want {
+			if got[table] != want {
+				t.Errorf("%v: %v has %v rows; want %v", run.name, table, got[table], want)
+			}
+		}
+		if stale := got["file where stale = 1"]; stale != 0 {
+			t.Errorf("%v: %v notes are still stale", run.name, stale)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package diatom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const EXIT_OK = 0
+const EXIT_FAILURE = 1
+const EXIT_USAGE = 2
+const EXIT_PARTIAL = 3
+const EXIT_PROBLEMS = 4
+const EXIT_INTERRUPTED = 5
+
+const FAILURE_FIND = "find"
+const FAILURE_READ = "read"
+const FAILURE_EXTRACT = "extract"
+const FAILURE_WALK = "walk"
+const FAILURE_WRITE = "write"
+const FAILURE_REMOVE = "remove"
+
+// A failure to index a single note. The rest of the run continues.
+type NoteError struct {
+	Path  string
+	Phase string
+	Err   error
+}
+
+func (err *NoteError) Error() string {
+	return fmt.Sprintf("%v %v: %v", err.Phase, err.Path, err.Err)
+}
+
+func (err *NoteError) Unwrap() error {
+	return err.Err
+}
+
+// The per-note failures collected over a run that otherwise completed
+type RunErrors struct {
+	Failures []*NoteError
+}
+
+func (err *RunErrors) Error() string {
+	if len(err.Failures) == 1 {
+		return "1 note failed"
+	}
+	return fmt.Sprintf("%v notes failed", len(err.Failures))
+}
+
+// A full-text search query SQLite could not parse, such as one with an unclosed quote
+type QueryError struct {
+	Query string
+	Err   error
+}
+
+func (err *QueryError) Error() string {
+	return fmt.Sprintf("invalid search query %q: %v", err.Query, err.Err)
+}
+
+func (err *QueryError) Unwrap() error {
+	return err.Err
+}
+
+/*
+ * The process exit code for an error returned by a diatom command
+ *
+ */
+func ExitCode(err error) int {
+	var runErrors *RunErrors
+	var queryErr *QueryError
+
+	switch {
+	case err == nil:
+		return EXIT_OK
+	case errors.As(err, &queryErr):
+		return EXIT_USAGE
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return EXIT_INTERRUPTED
+	case errors.As(err, &runErrors):
+		return EXIT_PARTIAL
+	default:
+		return EXIT_FAILURE
+	}
+}
+
+/*
+ * Write a summary of an error: each failed note grouped by phase when
+ * the run completed, otherwise the error and its context
+ *
+ */
+func WriteErrorSummary(err error, out io.Writer) {
+	var runErrors *RunErrors
+
+	if !errors.As(err, &runErrors) {
+		fmt.Fprintf(out, "diatom: %v\n", err)
+		return
+	}
+
+	failures := append([]*NoteError{}, runErrors.Failures...)
+	sort.SliceStable(failures, func(idx, jdx int) bool {
+		if failures[idx].Phase != failures[jdx].Phase {
+			return failures[idx].Phase < failures[jdx].Phase
+		}
+		return failures[idx].Path < failures[jdx].Path
+	})
+
+	fmt.Fprintf(out, "diatom: run completed, but %v:\n", runErrors.Error())
+	for _, failure := range failures {
+		fmt.Fprintf(out, "  %-8v %v: %v\n", failure.Phase, failure.Path, failure.Err)
+	}
+}
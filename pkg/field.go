@@ -0,0 +1,340 @@
+package diatom
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const FIELD_SOURCE_FRONTMATTER = "frontmatter"
+const FIELD_SOURCE_INLINE = "inline"
+
+const FIELD_TYPE_TEXT = "text"
+const FIELD_TYPE_NUMBER = "number"
+const FIELD_TYPE_BOOLEAN = "boolean"
+const FIELD_TYPE_DATE = "date"
+const FIELD_TYPE_LINK = "link"
+const FIELD_TYPE_LIST = "list"
+const FIELD_TYPE_OBJECT = "object"
+const FIELD_TYPE_NULL = "null"
+
+var (
+	fieldNumberPattern = regexp.MustCompile(`^-?\d+(?:\.\d+)?$`)
+	fieldDatePattern   = regexp.MustCompile(`^(\d{4}-\d{2}(?:-\d{2})?)(?:[T ]\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?)?$`)
+	fieldLinkPattern   = regexp.MustCompile(`^!?\[\[[^\[\]]+\]\]$`)
+)
+
+// The start of a line before its text: quote markers, a list marker, and a checkbox
+var linePrefixPattern = regexp.MustCompile(`^[ \t]*(?:>[ \t]*)*(?:(?:[-*+]|\d+[.)])[ \t]+(?:\[.\][ \t]+)?)?`)
+
+// A field filling the rest of its line, as `key:: value`; keys are letters, digits,
+// emoji, `_`, `-`, `/` and spaces, perhaps with emphasis, as in Dataview
+var fullLineFieldPattern = regexp.MustCompile(`^[_*~]*([\p{L}\p{N}\p{So}_][-\p{L}\p{N}\p{So}_/ \t]*?)[_*~]*[ \t]*::(.*)$`)
+
+var headingLinePattern = regexp.MustCompile(`^#{1,6}(?:[ \t]|$)`)
+
+/*
+ * Infer the type of a single value, as Dataview reads it
+ *
+ */
+func inferScalarType(value string) string {
+	value = strings.TrimSpace(value)
+
+	switch {
+	case value == "":
+		return FIELD_TYPE_NULL
+	case fieldNumberPattern.MatchString(value):
+		return FIELD_TYPE_NUMBER
+	case strings.EqualFold(value, "true") || strings.EqualFold(value, "false"):
+		return FIELD_TYPE_BOOLEAN
+	case fieldLinkPattern.MatchString(value):
+		return FIELD_TYPE_LINK
+	}
+
+	if match := fieldDatePattern.FindStringSubmatch(value); match != nil {
+		layout := "2006-01-02"
+		if len(match[1]) == len("2006-01") {
+			layout = "2006-01"
+		}
+
+		if _, err := time.Parse(layout, match[1]); err == nil {
+			return FIELD_TYPE_DATE
+		}
+	}
+
+	return FIELD_TYPE_TEXT
+}
+
+/*
+ * Infer the type of an inline field's value. Comma-separated numbers, booleans,
+ * dates or links are a list; any other value containing text is text.
+ *
+ */
+func InferFieldType(value string) string {
+	if valueType := inferScalarType(value); valueType != FIELD_TYPE_TEXT {
+		return valueType
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) < 2 {
+		return FIELD_TYPE_TEXT
+	}
+
+	for _, part := range parts {
+		if valueType := inferScalarType(part); valueType == FIELD_TYPE_TEXT || valueType == FIELD_TYPE_NULL {
+			return FIELD_TYPE_TEXT
+		}
+	}
+
+	return FIELD_TYPE_LIST
+}
+
+/*
+ * Blank out inline code in a line, keeping its length, so
+ * fields within code are not read
+ *
+ */
+func maskInlineCode(line string) string {
+	masked := []byte(line)
+
+	// the length of the run of backticks starting at an index
+	run := func(idx int) int {
+		end := idx
+		for end < len(line) && line[end] == '`' {
+			end++
+		}
+		return end - idx
+	}
+
+	for idx := 0; idx < len(line); {
+		if line[idx] != '`' {
+			idx++
+			continue
+		}
+
+		// a code span closes with a run of backticks as long as the one opening it
+		fence, end := run(idx), -1
+		for jdx := idx + fence; jdx < len(line) && end < 0; {
+			if line[jdx] != '`' {
+				jdx++
+			} else if length := run(jdx); length == fence {
+				end = jdx + length
+			} else {
+				jdx += length
+			}
+		}
+
+		if end < 0 {
+			idx += fence
+			continue
+		}
+
+		for jdx := idx; jdx < end; jdx++ {
+			masked[jdx] = ' '
+		}
+		idx = end
+	}
+
+	return string(masked)
+}
+
+/*
+ * A field's key, without the emphasis Dataview allows around it, such as
+ * `**key**`. Keys may not contain brackets, colons or backticks.
+ *
+ */
+func fieldKey(key string) (string, bool) {
+	if strings.ContainsAny(key, "[]():`") {
+		return "", false
+	}
+
+	key = strings.Trim(key, " \t*_~=")
+	return key, key != ""
+}
+
+/*
+ * Find bracketed fields in a line: `[key:: value]`, or `(key:: value)`, which
+ * Obsidian shows without its key. Returns the bounds of each field's span, key
+ * and value.
+ *
+ */
+func findBracketedFields(line string) [][6]int {
+	fields := [][6]int{}
+
+	for idx := 0; idx < len(line); idx++ {
+		open := line[idx]
+		if open != '[' && open != '(' {
+			continue
+		}
+
+		// the inner bracket of a wikilink does not open a field
+		if open == '[' && idx > 0 && line[idx-1] == '[' {
+			continue
+		}
+
+		close := byte(']')
+		if open == '(' {
+			close = ')'
+		}
+
+		sep := strings.Index(line[idx+1:], "::")
+		if sep < 0 {
+			break
+		}
+		keyEnd := idx + 1 + sep
+
+		if _, ok := fieldKey(line[idx+1 : keyEnd]); !ok {
+			continue
+		}
+
+		// the value runs to the matching bracket, so it may hold links
+		depth, end := 0, -1
+		for jdx := keyEnd + 2; jdx < len(line) && end < 0; jdx++ {
+			switch line[jdx] {
+			case open:
+				depth++
+			case close:
+				if depth == 0 {
+					end = jdx
+				}
+				depth--
+			}
+		}
+
+		if end < 0 {
+			continue
+		}
+
+		fields = append(fields, [6]int{idx, end + 1, idx + 1, keyEnd, keyEnd + 2, end})
+		idx = end
+	}
+
+	return fields
+}
+
+/*
+ * Read Dataview inline fields from a note's source: `key:: value` filling a line,
+ * perhaps within a list item or quote, and `[key:: value]` or `(key:: value)`
+ * within a line. Headings, inline code, and the given spans (such as fenced
+ * code-blocks) are skipped.
+ *
+ */
+func ReadInlineFields(source Source, skip [][2]int) []Field {
+	fields := []Field{}
+
+	newField := func(key, value string, start, end int) Field {
+		value = strings.TrimSpace(value)
+
+		return Field{
+			Source:   FIELD_SOURCE_INLINE,
+			Key:      key,
+			Value:    value,
+			Type:     InferFieldType(value),
+			Position: source.Position(start, end),
+		}
+	}
+
+	skipped := func(idx int) bool {
+		for _, span := range skip {
+			if idx >= span[0] && idx < span[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	for lineStart := 0; lineStart < len(source.Text); {
+		start, end := lineBounds(source.Text, lineStart)
+		end = start + len(strings.TrimRight(source.Text[start:end], " \t"))
+
+		lineStart = len(source.Text)
+		if next := strings.Index(source.Text[start:], "\n"); next >= 0 {
+			lineStart = start + next + 1
+		}
+
+		line := maskInlineCode(source.Text[start:end])
+		if skipped(start) || headingLinePattern.MatchString(strings.TrimLeft(line, " ")) {
+			continue
+		}
+
+		prefix := len(linePrefixPattern.FindString(line))
+		if match := fullLineFieldPattern.FindStringSubmatchIndex(line[prefix:]); match != nil {
+			if key, ok := fieldKey(line[prefix+match[2] : prefix+match[3]]); ok {
+				fields = append(fields, newField(key, source.Text[start+prefix+match[4]:end], start+prefix, end))
+			}
+		}
+
+		for _, bounds := range findBracketedFields(line) {
+			key, _ := fieldKey(line[bounds[2]:bounds[3]])
+			value := source.Text[start+bounds[4] : start+bounds[5]]
+
+			fields = append(fields, newField(key, value, start+bounds[0], start+bounds[1]))
+		}
+	}
+
+	return fields
+}
+
+/*
+ * Read a field for each top-level frontmatter key, from the decoded frontmatter.
+ * Text values are stored as text, and other values as json. A field's span is
+ * the line its key is on, in the yaml.
+ *
+ */
+func FrontmatterFields(values map[string]interface{}, yamlText string) ([]Field, error) {
+	source := Source{Text: yamlText, Offset: 0, Line: 1}
+	fields := []Field{}
+
+	for key, value := range values {
+		field := Field{Source: FIELD_SOURCE_FRONTMATTER, Key: key}
+
+		switch value := value.(type) {
+		case nil:
+			field.Type = FIELD_TYPE_NULL
+		case bool:
+			field.Type = FIELD_TYPE_BOOLEAN
+		case float64:
+			field.Type = FIELD_TYPE_NUMBER
+		case []interface{}:
+			field.Type = FIELD_TYPE_LIST
+		case map[string]interface{}:
+			field.Type = FIELD_TYPE_OBJECT
+		case string:
+			// yaml strings may still be dates or links, but not numbers
+			field.Value = value
+			field.Type = inferScalarType(value)
+			if field.Type != FIELD_TYPE_DATE && field.Type != FIELD_TYPE_LINK {
+				field.Type = FIELD_TYPE_TEXT
+			}
+		}
+
+		// values other than text are stored as json
+		if _, text := value.(string); !text && value != nil {
+			bytes, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			field.Value = string(bytes)
+		}
+
+		keyLine := regexp.MustCompile(`(?m)^["']?` + regexp.QuoteMeta(key) + `["']?[ \t]*:`)
+		if bounds := keyLine.FindStringIndex(yamlText); bounds != nil {
+			start, end := lineBounds(yamlText, bounds[0])
+			field.Position = source.Position(start, end)
+		}
+
+		fields = append(fields, field)
+	}
+
+	// in the order the keys are written
+	sort.Slice(fields, func(idx, jdx int) bool {
+		if fields[idx].Start != fields[jdx].Start {
+			return fields[idx].Start < fields[jdx].Start
+		}
+		return fields[idx].Key < fields[jdx].Key
+	})
+
+	return fields, nil
+}
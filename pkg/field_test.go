@@ -0,0 +1,222 @@
+package diatom
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFrontmatterFieldsMatchProperties(t *testing.T) {
+	cases := []struct {
+		name      string
+		yaml      string
+		key       string
+		fieldType string
+		value     string
+		texts     []string
+	}{
+		{name: "y", yaml: "answer: y\n", key: "answer", fieldType: FIELD_TYPE_TEXT, value: "y", texts: []string{"y"}},
+		{name: "on", yaml: "on: yes\n", key: "on", fieldType: FIELD_TYPE_TEXT, value: "yes", texts: []string{"yes"}},
+		{name: "no", yaml: "answer: no\n", key: "answer", fieldType: FIELD_TYPE_TEXT, value: "no", texts: []string{"no"}},
+		{name: "list", yaml: "tags: [x, y]\n", key: "tags", fieldType: FIELD_TYPE_LIST, value: `["x","y"]`, texts: []string{"x", "y"}},
+	}
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			note := NewNote("note.md")
+			if err := note.ExtractData("---\n"+tc.yaml+"---\nbody\n", cfg); err != nil {
+				t.Fatal(err)
+			}
+			note.ReadProperties(nil)
+
+			fields := note.data.Fields
+			if len(fields) != 1 || fields[0].Key != tc.key || fields[0].Type != tc.fieldType || fields[0].Value != tc.value {
+				t.Errorf("fields for %q: got %+v, want %v %v %v", tc.yaml, fields, tc.key, tc.fieldType, tc.value)
+			}
+
+			texts := []string{}
+			for _, property := range note.data.Properties {
+				if property.Key != tc.key || property.Boolean != nil || property.Number != nil {
+					t.Errorf("property for %q: got %+v, want text under %v", tc.yaml, property, tc.key)
+				}
+				texts = append(texts, property.Text)
+			}
+
+			if len(texts) != len(tc.texts) {
+				t.Fatalf("properties for %q: got %v, want %v", tc.yaml, texts, tc.texts)
+			}
+			for idx := range texts {
+				if texts[idx] != tc.texts[idx] {
+					t.Errorf("properties for %q: got %v, want %v", tc.yaml, texts, tc.texts)
+				}
+			}
+		})
+	}
+}
+
+func TestInferFieldType(t *testing.T) {
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{"", FIELD_TYPE_NULL},
+		{"  ", FIELD_TYPE_NULL},
+		{"5", FIELD_TYPE_NUMBER},
+		{"-2.5", FIELD_TYPE_NUMBER},
+		{"1.2.3", FIELD_TYPE_TEXT},
+		{"true", FIELD_TYPE_BOOLEAN},
+		{"FALSE", FIELD_TYPE_BOOLEAN},
+		{"yes", FIELD_TYPE_TEXT},
+		{"2024-01-02", FIELD_TYPE_DATE},
+		{"2024-01", FIELD_TYPE_DATE},
+		{"2024-01-02T10:30", FIELD_TYPE_DATE},
+		{"2024-01-02 10:30:00Z", FIELD_TYPE_DATE},
+		{"2024-13-01", FIELD_TYPE_TEXT},
+		{"[[Note]]", FIELD_TYPE_LINK},
+		{"[[Note|alias]]", FIELD_TYPE_LINK},
+		{"see [[Note]]", FIELD_TYPE_TEXT},
+		{"1, 2, 3", FIELD_TYPE_LIST},
+		{"[[A]], [[B]]", FIELD_TYPE_LIST},
+		{"1, two", FIELD_TYPE_TEXT},
+		{"1,", FIELD_TYPE_TEXT},
+		{"plain text", FIELD_TYPE_TEXT},
+	}
+
+	for _, tc := range cases {
+		if got := InferFieldType(tc.value); got != tc.want {
+			t.Errorf("InferFieldType(%q) = %q; want %q", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestReadInlineFields(t *testing.T) {
+	// a field's key, value and type, and the source its span covers
+	type field struct {
+		Key   string
+		Value string
+		Type  string
+		Span  string
+	}
+
+	cases := []struct {
+		name string
+		text string
+		want []field
+	}{
+		{
+			name: "a field filling its line",
+			text: "rating:: 5\n",
+			want: []field{{"rating", "5", FIELD_TYPE_NUMBER, "rating:: 5"}},
+		},
+		{
+			name: "keys with spaces, emphasis and emoji",
+			text: "**Due Date**:: 2024-01-02\n🎯 goal:: win\n",
+			want: []field{
+				{"Due Date", "2024-01-02", FIELD_TYPE_DATE, "**Due Date**:: 2024-01-02"},
+				{"🎯 goal", "win", FIELD_TYPE_TEXT, "🎯 goal:: win"},
+			},
+		},
+		{
+			name: "list items and quotes",
+			text: "- status:: done\n> up:: [[Home]]\n",
+			want: []field{
+				{"status", "done", FIELD_TYPE_TEXT, "status:: done"},
+				{"up", "[[Home]]", FIELD_TYPE_LINK, "up:: [[Home]]"},
+			},
+		},
+		{
+			name: "bracketed fields",
+			text: "I read it [rating:: 9] and (done:: true) today\n",
+			want: []field{
+				{"rating", "9", FIELD_TYPE_NUMBER, "[rating:: 9]"},
+				{"done", "true", FIELD_TYPE_BOOLEAN, "(done:: true)"},
+			},
+		},
+		{
+			name: "bracketed values holding links",
+			text: "[up:: [[Home]]] and [[Not:: a field]]\n",
+			want: []field{{"up", "[[Home]]", FIELD_TYPE_LINK, "[up:: [[Home]]]"}},
+		},
+		{
+			name: "an empty value",
+			text: "empty::\n",
+			want: []field{{"empty", "", FIELD_TYPE_NULL, "empty::"}},
+		},
+		{
+			name: "headings, inline code and skipped spans",
+			text: "# title:: no\n`code:: no` and [x:: 1]\n```\nfenced:: no\n```\n",
+			want: []field{{"x", "1", FIELD_TYPE_NUMBER, "[x:: 1]"}},
+		},
+		{
+			name: "urls and single colons are not fields",
+			text: "see https://example.com\nkey: value\n",
+			want: []field{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// skip any fenced code-block, as the walk does
+			skip := [][2]int{}
+			if start := strings.Index(tc.text, "```"); start >= 0 {
+				skip = append(skip, [2]int{start, strings.LastIndex(tc.text, "```") + 3})
+			}
+
+			got := []field{}
+			for _, read := range ReadInlineFields(Source{Text: tc.text, Offset: 0, Line: 1}, skip) {
+				if read.Source != FIELD_SOURCE_INLINE {
+					t.Errorf("field %v has source %q; want %q", read.Key, read.Source, FIELD_SOURCE_INLINE)
+				}
+				got = append(got, field{read.Key, read.Value, read.Type, tc.text[read.Start:read.End]})
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ReadInlineFields(%q)\n got: %+v\nwant: %+v", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInlineFieldsInTasksAndSections(t *testing.T) {
+	text := "top:: 1\n# A\na:: 2\n## B\n- [ ] task [due:: 2024-01-02]\n  - [ ] sub (p:: high)\n  - [x] sub task\n    status:: done\n\n    owner:: me\n  after:: 4\n- item:: 3\n"
+
+	// each field's section and task. Fields on a task's continuation lines belong to
+	// it, even after a blank line, and a field after a sub-task belongs to its parent
+	want := map[string][2]int{
+		"top":    {0, 0},
+		"a":      {1, 0},
+		"due":    {2, 1},
+		"p":      {2, 2},
+		"status": {2, 3},
+		"owner":  {2, 3},
+		"after":  {2, 1},
+		"item":   {2, 0},
+	}
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	note := NewNote("note.md")
+	if err := note.ExtractData(text, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := note.Walk(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][2]int{}
+	for _, field := range note.data.Fields {
+		got[field.Key] = [2]int{field.SectionId, field.TaskId}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("field sections and tasks in %q:\n got: %v\nwant: %v", text, got, want)
+	}
+}
@@ -0,0 +1,899 @@
+package diatom
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// A step in the database schema's history. Statements on the full-text index run
+// after the others, and only when SQLite has FTS5.
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+	FullText    []string
+}
+
+// The full-text index over notes, as of the latest schema
+var FULL_TEXT_SCHEMA = []string{
+	`create virtual table note_text using fts5(
+		file_id unindexed,
+		title,
+		headings,
+		body,
+
+		tokenize = 'porter unicode61'
+	)`,
+	// virtual tables cannot declare foreign keys
+	`create trigger file_delete_note_text after delete on file begin
+		delete from note_text where file_id = old.id;
+	end`,
+}
+
+// Schema migrations, in the order they are applied. Append new steps; never edit
+// a released one. Steps that change how notes are extracted clear stored hashes
+// (and, from version 8, mtimes) so every note is re-read on the next run.
+var MIGRATIONS = []Migration{
+	{
+		Version:     1,
+		Description: "create file, tag, url, wikilink, metadata and heading tables",
+		Statements: []string{
+			`create table if not exists file (
+				id         text not null,
+				basename   text not null,
+				title      text not null,
+				hash       text not null,
+				in_degree  integer default 0    check(in_degree  >= 0),
+				out_degree integer default 0    check(out_degree >= 0),
+
+				primary key(id)
+			)`,
+			`create table if not exists tag (
+				tag      text not null,
+				file_id  text not null,
+
+				primary key(tag, file_id)
+			)`,
+			`create table if not exists url (
+				url      text not null,
+				file_id  text not null,
+
+				primary key(url, file_id)
+			)`,
+			`create table if not exists wikilink (
+				reference text not null,
+				alias    text,
+				file_id  text not null,
+
+				primary key(reference, alias, file_id)
+			)`,
+			`create table if not exists metadata (
+				file_id  text not null,
+				schema   text not null,
+				content  text not null,
+
+				primary key(file_id, content)
+			)`,
+			`create table if not exists heading (
+				heading  text not null,
+				level    integer not null,
+				file_id  text not null,
+
+				primary key(heading, level, file_id)
+			)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "store scheme, host, path, text and line for urls",
+		Statements: []string{
+			`drop table if exists url`,
+			`create table url (
+				url      text not null,
+				scheme   text not null,
+				host     text not null,
+				path     text not null,
+				text     text,
+				line     integer not null,
+				file_id  text not null,
+
+				primary key(url, file_id, line)
+			)`,
+			`create index url_host on url(host)`,
+			`update file set hash = ''`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "store wikilink targets, embeds, lines and resolutions",
+		Statements: []string{
+			`drop table if exists wikilink`,
+			`create table wikilink (
+				reference        text not null,
+				alias            text,
+				file_id          text not null,
+				target           text not null,
+				heading          text,
+				block_id         text,
+				is_embed         integer not null default 0 check(is_embed in (0, 1)),
+				resolved_file_id text,
+				is_resolved      integer not null default 0 check(is_resolved in (0, 1)),
+				line             integer,
+
+				primary key(reference, alias, file_id, is_embed)
+			)`,
+			`create index wikilink_resolved_file_id on wikilink(resolved_file_id)`,
+			`update file set hash = ''`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "reference file(id) from child tables, cascading deletes",
+		Statements: []string{
+			`create table tag_new (
+				tag      text not null,
+				file_id  text not null,
+
+				primary key(tag, file_id),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`insert into tag_new select tag, file_id from tag where file_id in (select id from file)`,
+			`drop table tag`,
+			`alter table tag_new rename to tag`,
+
+			`create table url_new (
+				url      text not null,
+				scheme   text not null,
+				host     text not null,
+				path     text not null,
+				text     text,
+				line     integer not null,
+				file_id  text not null,
+
+				primary key(url, file_id, line),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`insert into url_new select url, scheme, host, path, text, line, file_id
+				from url where file_id in (select id from file)`,
+			`drop table url`,
+			`alter table url_new rename to url`,
+			`create index url_host on url(host)`,
+
+			`create table wikilink_new (
+				reference        text not null,
+				alias            text,
+				file_id          text not null,
+				target           text not null,
+				heading          text,
+				block_id         text,
+				is_embed         integer not null default 0 check(is_embed in (0, 1)),
+				resolved_file_id text,
+				is_resolved      integer not null default 0 check(is_resolved in (0, 1)),
+				line             integer,
+
+				primary key(reference, alias, file_id, is_embed),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`insert into wikilink_new
+				select reference, alias, file_id, target, heading, block_id, is_embed, resolved_file_id, is_resolved, line
+				from wikilink where file_id in (select id from file)`,
+			`drop table wikilink`,
+			`alter table wikilink_new rename to wikilink`,
+			`create index wikilink_resolved_file_id on wikilink(resolved_file_id)`,
+
+			`create table metadata_new (
+				file_id  text not null,
+				schema   text not null,
+				content  text not null,
+
+				primary key(file_id, content),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`insert into metadata_new select file_id, schema, content from metadata where file_id in (select id from file)`,
+			`drop table metadata`,
+			`alter table metadata_new rename to metadata`,
+
+			`create table heading_new (
+				heading  text not null,
+				level    integer not null,
+				file_id  text not null,
+
+				primary key(heading, level, file_id),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`insert into heading_new select heading, level, file_id from heading where file_id in (select id from file)`,
+			`drop table heading`,
+			`alter table heading_new rename to heading`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "full-text index over note titles, headings and text",
+		Statements: []string{
+			`update file set hash = ''`,
+		},
+		FullText: FULL_TEXT_SCHEMA,
+	},
+	{
+		Version:     6,
+		Description: "record note renames in a file_history table",
+		Statements: []string{
+			`create table file_history (
+				file_id     text not null,
+				previous_id text not null,
+				reason      text not null,
+				moved_at    text not null
+			)`,
+			`create index file_history_file_id on file_history(file_id)`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "key notes on a stable id, and store vault-relative paths",
+		// ids were absolute paths, which cannot be made vault-relative without the vault;
+		// the tables are rebuilt empty and every note is re-read on the next run
+		Statements: []string{
+			`drop table tag`,
+			`drop table url`,
+			`drop table wikilink`,
+			`drop table metadata`,
+			`drop table heading`,
+			`drop table file_history`,
+			`drop table file`,
+			`create table file (
+				id         text not null,
+				path       text not null,
+				basename   text not null,
+				title      text not null,
+				hash       text not null,
+				in_degree  integer default 0    check(in_degree  >= 0),
+				out_degree integer default 0    check(out_degree >= 0),
+
+				primary key(id),
+				unique(path)
+			)`,
+			`create table tag (
+				tag      text not null,
+				file_id  text not null,
+
+				primary key(tag, file_id),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create table url (
+				url      text not null,
+				scheme   text not null,
+				host     text not null,
+				path     text not null,
+				text     text,
+				line     integer not null,
+				file_id  text not null,
+
+				primary key(url, file_id, line),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index url_host on url(host)`,
+			`create table wikilink (
+				reference        text not null,
+				alias            text,
+				file_id          text not null,
+				target           text not null,
+				heading          text,
+				block_id         text,
+				is_embed         integer not null default 0 check(is_embed in (0, 1)),
+				resolved_file_id text,
+				is_resolved      integer not null default 0 check(is_resolved in (0, 1)),
+				line             integer,
+
+				primary key(reference, alias, file_id, is_embed),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index wikilink_resolved_file_id on wikilink(resolved_file_id)`,
+			`create table metadata (
+				file_id  text not null,
+				schema   text not null,
+				content  text not null,
+
+				primary key(file_id, content),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create table heading (
+				heading  text not null,
+				level    integer not null,
+				file_id  text not null,
+
+				primary key(heading, level, file_id),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create table file_history (
+				file_id       text not null,
+				previous_path text not null,
+				path          text not null,
+				reason        text not null,
+				moved_at      text not null
+			)`,
+			`create index file_history_file_id on file_history(file_id)`,
+		},
+		// dropping the file table dropped its trigger
+		FullText: []string{
+			`delete from note_text`,
+			`create trigger file_delete_note_text after delete on file begin
+				delete from note_text where file_id = old.id;
+			end`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "store sha-256 hashes, mtimes and sizes for files",
+		Statements: []string{
+			`alter table file add column mtime integer not null default 0`,
+			`alter table file add column size integer not null default 0`,
+			`update file set hash = ''`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "record each run, with its counters, phase timings and file changes",
+		Statements: []string{
+			`create table run (
+				id          integer not null,
+				started_at  text not null,
+				finished_at text not null,
+				vault       text not null,
+				version     text not null,
+				error       text,
+
+				primary key(id)
+			)`,
+			`create table run_stat (
+				run_id  integer not null,
+				name    text not null,
+				value   integer not null,
+
+				primary key(run_id, name),
+				foreign key(run_id) references run(id) on delete cascade
+			)`,
+			`create table run_phase (
+				run_id      integer not null,
+				phase       text not null,
+				duration_ms integer not null,
+
+				primary key(run_id, phase),
+				foreign key(run_id) references run(id) on delete cascade
+			)`,
+			`create table run_file (
+				run_id  integer not null,
+				path    text not null,
+				change  text not null check(change in ('added', 'changed', 'deleted')),
+
+				foreign key(run_id) references run(id) on delete cascade
+			)`,
+			`create index run_file_run_id on run_file(run_id)`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "record parse and extraction problems in a diagnostic table",
+		Statements: []string{
+			`create table diagnostic (
+				file_id   text not null,
+				line      integer not null,
+				column    integer not null default 0,
+				severity  text not null check(severity in ('error', 'warning')),
+				extractor text not null,
+				message   text not null,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index diagnostic_file_id on diagnostic(file_id)`,
+			`update file set hash = '', mtime = 0`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "store source positions on tags, urls, wikilinks, metadata and headings",
+		// every occurrence is now a row, so the tables are keyed on rowid rather
+		// than their content; they are rebuilt empty and refilled on the next run
+		Statements: []string{
+			`drop table tag`,
+			`drop table url`,
+			`drop table wikilink`,
+			`drop table metadata`,
+			`drop table heading`,
+			`create table tag (
+				tag          text not null,
+				file_id      text not null,
+				start_offset integer not null,
+				end_offset   integer not null,
+				line         integer not null,
+				column       integer not null,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index tag_tag on tag(tag)`,
+			`create index tag_file_id on tag(file_id)`,
+			`create table url (
+				url          text not null,
+				scheme       text not null,
+				host         text not null,
+				path         text not null,
+				text         text,
+				file_id      text not null,
+				start_offset integer not null,
+				end_offset   integer not null,
+				line         integer not null,
+				column       integer not null,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index url_host on url(host)`,
+			`create index url_file_id on url(file_id)`,
+			`create table wikilink (
+				reference        text not null,
+				alias            text,
+				file_id          text not null,
+				target           text not null,
+				heading          text,
+				block_id         text,
+				is_embed         integer not null default 0 check(is_embed in (0, 1)),
+				resolved_file_id text,
+				is_resolved      integer not null default 0 check(is_resolved in (0, 1)),
+				start_offset     integer not null,
+				end_offset       integer not null,
+				line             integer not null,
+				column           integer not null,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index wikilink_resolved_file_id on wikilink(resolved_file_id)`,
+			`create index wikilink_file_id on wikilink(file_id)`,
+			`create table metadata (
+				file_id      text not null,
+				schema       text not null,
+				content      text not null,
+				start_offset integer not null,
+				end_offset   integer not null,
+				line         integer not null,
+				column       integer not null,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index metadata_file_id on metadata(file_id)`,
+			`create table heading (
+				heading      text not null,
+				level        integer not null,
+				file_id      text not null,
+				start_offset integer not null,
+				end_offset   integer not null,
+				line         integer not null,
+				column       integer not null,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index heading_file_id on heading(file_id)`,
+			`update file set hash = '', mtime = 0, in_degree = 0, out_degree = 0`,
+		},
+	},
+	{
+		Version:     12,
+		Description: "read tags and wikilinks from the markdown AST, ignoring code and html",
+		Statements: []string{
+			`update file set hash = '', mtime = 0`,
+		},
+	},
+	{
+		Version:     13,
+		Description: "index attachments, and record whether wikilinks are embeds",
+		Statements: []string{
+			`create table attachment (
+				path      text not null,
+				extension text not null,
+				mime_type text not null,
+				size      integer not null,
+				mtime     integer not null,
+				hash      text not null,
+
+				primary key(path)
+			)`,
+			`alter table wikilink add column type text not null default 'link'
+				check(type in ('link', 'note-embed', 'attachment-embed'))`,
+			`alter table wikilink add column resolved_attachment text`,
+			`create index wikilink_resolved_attachment on wikilink(resolved_attachment)`,
+			`update file set hash = '', mtime = 0`,
+		},
+	},
+	{
+		Version:     14,
+		Description: "index ^block-id blocks, and heading anchor slugs",
+		Statements: []string{
+			`create table block (
+				file_id      text not null,
+				block_id     text not null,
+				type         text not null check(type in ('paragraph', 'list-item', 'list', 'table', 'quote', 'code')),
+				text         text not null,
+				start_offset integer not null,
+				end_offset   integer not null,
+				line         integer not null,
+				column       integer not null,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index block_file_id on block(file_id)`,
+			`alter table heading add column slug text not null default ''`,
+			`alter table wikilink add column heading_slug text`,
+			`update file set hash = '', mtime = 0`,
+		},
+	},
+	{
+		Version:     15,
+		Description: "index sections, with their heading hierarchy and text",
+		Statements: []string{
+			`create table section (
+				file_id      text not null,
+				section_id   integer not null,
+				parent_id    integer,
+				level        integer not null,
+				heading      text not null,
+				text         text not null,
+				start_offset integer not null,
+				end_offset   integer not null,
+				line         integer not null,
+				column       integer not null,
+
+				primary key(file_id, section_id),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`update file set hash = '', mtime = 0`,
+		},
+	},
+	{
+		Version:     16,
+		Description: "index tasks, with Tasks plugin metadata, and the tags and wikilinks in them",
+		Statements: []string{
+			`create table task (
+				file_id      text not null,
+				task_id      integer not null,
+				parent_id    integer,
+				status       text not null,
+				text         text not null,
+				priority     text not null check(priority in ('highest', 'high', 'medium', 'normal', 'low', 'lowest')),
+				recurrence   text,
+				created      text,
+				start        text,
+				scheduled    text,
+				due          text,
+				cancelled    text,
+				done         text,
+				start_offset integer not null,
+				end_offset   integer not null,
+				line         integer not null,
+				column       integer not null,
+
+				primary key(file_id, task_id),
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index task_due on task(due)`,
+			`alter table tag add column task_id integer`,
+			`alter table wikilink add column task_id integer`,
+			`update file set hash = '', mtime = 0`,
+		},
+	},
+	{
+		Version:     17,
+		Description: "index Dataview inline fields, alongside top-level frontmatter keys",
+		Statements: []string{
+			`create table field (
+				file_id      text not null,
+				source       text not null check(source in ('frontmatter', 'inline')),
+				key          text not null,
+				value        text not null,
+				type         text not null check(type in ('text', 'number', 'boolean', 'date', 'link', 'list', 'object', 'null')),
+				section_id   integer,
+				task_id      integer,
+				start_offset integer not null,
+				end_offset   integer not null,
+				line         integer not null,
+				column       integer not null,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index field_file_id on field(file_id)`,
+			`create index field_key on field(key collate nocase, value)`,
+			`update file set hash = '', mtime = 0`,
+		},
+	},
+	{
+		Version:     18,
+		Description: "index typed frontmatter properties, and the types declared in .obsidian/types.json",
+		Statements: []string{
+			`create table property (
+				file_id    text not null,
+				key        text not null,
+				list_index integer,
+				type       text not null,
+				text       text,
+				number     real,
+				date       text,
+				boolean    integer,
+				link       text,
+
+				foreign key(file_id) references file(id) on delete cascade
+			)`,
+			`create index property_file_id on property(file_id)`,
+			`create index property_text on property(key, text) where text is not null`,
+			`create index property_number on property(key, number) where number is not null`,
+			`create index property_date on property(key, date) where date is not null`,
+			`create index property_link on property(link) where link is not null`,
+			`create table property_type (
+				key  text primary key,
+				type text not null
+			)`,
+			`update file set hash = '', mtime = 0`,
+		},
+	},
+	{
+		Version:     19,
+		Description: "record the vault each database indexes",
+		Statements: []string{
+			`create table vault (
+				id   integer primary key check(id = 1),
+				path text not null
+			)`,
+			// the vault of the last successful run, if any
+			`insert into vault (id, path)
+				select 1, vault from run where error is null order by id desc limit 1`,
+		},
+	},
+	{
+		Version:     20,
+		Description: "mark notes whose properties need reading again, keeping their hash",
+		Statements: []string{
+			`alter table file add column properties_stale integer not null default 0`,
+		},
+	},
+	{
+		Version:     21,
+		Description: "record the extractors notes were read with, and mark notes stale when they change",
+		Statements: []string{
+			`alter table file rename column properties_stale to stale`,
+			// empty until the next run, which reads every note again with the extractors it records
+			`create table extractor (
+				name text primary key
+			)`,
+		},
+	},
+}
+
+// The schema version this binary writes
+func LatestSchemaVersion() int {
+	return MIGRATIONS[len(MIGRATIONS)-1].Version
+}
+
+/*
+ * Get the schema version of the database. Databases created before
+ * versioning have version zero.
+ *
+ */
+func (conn *ObsidianDB) SchemaVersion() (int, error) {
+	var tables int
+	err := conn.Db.QueryRow(`
+	select count(*) from sqlite_master where type = 'table' and name = 'schema_version'
+	`).Scan(&tables)
+
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+
+	var version int
+	err = conn.Db.QueryRow(`select coalesce(max(version), 0) from schema_version`).Scan(&version)
+
+	return version, err
+}
+
+/*
+ * List migrations not yet applied to the database. A database written
+ * by a newer diatom is refused, rather than risk corrupting it.
+ *
+ */
+func (conn *ObsidianDB) PendingMigrations() ([]Migration, error) {
+	version, err := conn.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if version > LatestSchemaVersion() {
+		return nil, fmt.Errorf("database schema version %v is newer than this diatom supports (%v); upgrade diatom", version, LatestSchemaVersion())
+	}
+
+	pending := []Migration{}
+	for _, migration := range MIGRATIONS {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+/*
+ * Apply a single migration, and record it, in one transaction
+ *
+ */
+func (conn *ObsidianDB) ApplyMigration(migration Migration) error {
+	statements := migration.Statements
+
+	// without FTS5 the full-text index is left out, and created once SQLite has it;
+	// checked before the transaction begins, so one connection is enough
+	fullText, err := conn.HasFTS5()
+	if err != nil {
+		return err
+	}
+	if fullText {
+		statements = append(append([]string{}, statements...), migration.FullText...)
+	}
+
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`create table if not exists schema_version (
+		version     integer not null,
+		description text    not null,
+		applied_at  text    not null,
+
+		primary key(version)
+	)`)
+
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("migration %v: %v", migration.Version, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+	insert into schema_version (version, description, applied_at) values (?, ?, ?)
+	`, migration.Version, migration.Description, time.Now().UTC().Format(time.RFC3339))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Bring the database schema up to date
+ *
+ */
+func (conn *ObsidianDB) Migrate() error {
+	pending, err := conn.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		if err := conn.ApplyMigration(migration); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.EnsureFullText()
+	return err
+}
+
+/*
+ * Does this build of SQLite have FTS5? Without the sqlite_fts5 build tag, it does
+ * not, and notes are indexed without their full text.
+ *
+ */
+func (conn *ObsidianDB) HasFTS5() (bool, error) {
+	var enabled bool
+	err := conn.Db.QueryRow(`select sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled)
+
+	return enabled, err
+}
+
+/*
+ * Does the database have a full-text index?
+ *
+ */
+func (conn *ObsidianDB) HasFullText() (bool, error) {
+	var tables int
+	err := conn.Db.QueryRow(`
+	select count(*) from sqlite_master where type = 'table' and name = 'note_text'
+	`).Scan(&tables)
+
+	return tables > 0, err
+}
+
+/*
+ * Create the full-text index in a database migrated without FTS5, once SQLite
+ * has it. Every note is re-read on the next run, to fill the index. Returns
+ * whether the index was created. A database with a full-text index cannot be
+ * written without FTS5, so is refused.
+ *
+ */
+func (conn *ObsidianDB) EnsureFullText() (bool, error) {
+	fts5, err := conn.HasFTS5()
+	if err != nil {
+		return false, err
+	}
+
+	exists, err := conn.HasFullText()
+	if err != nil {
+		return false, err
+	}
+
+	if exists && !fts5 {
+		return false, fmt.Errorf("the database has a full-text index, which needs SQLite with FTS5; build diatom with -tags sqlite_fts5")
+	}
+
+	if exists || !fts5 {
+		return false, nil
+	}
+
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range append(append([]string{}, FULL_TEXT_SCHEMA...), `update file set hash = '', mtime = 0`) {
+		if _, err := tx.Exec(statement); err != nil {
+			return false, fmt.Errorf("failure creating full-text index: %v", err)
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+/*
+ * Migrate the database, reporting each step. A dry-run lists the
+ * pending steps without applying them.
+ *
+ */
+func Migrate(args *DiatomArgs, dryRun bool, out io.Writer) error {
+	conn, err := NewDB(args.Config.DBPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	pending, err := conn.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		fmt.Fprintf(out, "schema is up to date (version %v)\n", LatestSchemaVersion())
+	}
+
+	for _, migration := range pending {
+		if dryRun {
+			fmt.Fprintf(out, "would apply %v: %v\n", migration.Version, migration.Description)
+			continue
+		}
+
+		if err := conn.ApplyMigration(migration); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %v: %v\n", migration.Version, migration.Description)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	created, err := conn.EnsureFullText()
+	if created {
+		fmt.Fprintf(out, "created the full-text index\n")
+	}
+
+	return err
+}
@@ -0,0 +1,330 @@
+package diatom
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The schema diatom created before it was versioned, with a note keyed on its absolute path
+var baselineSchema = []string{
+	`create table if not exists file (
+		id         text not null,
+		basename   text not null,
+		title      text not null,
+		hash       text not null,
+		in_degree  integer default 0    check(in_degree  >= 0),
+		out_degree integer default 0    check(out_degree >= 0),
+
+		primary key(id)
+	)`,
+	`create table if not exists tag (
+		tag      text not null,
+		file_id  text not null,
+
+		primary key(tag, file_id)
+	)`,
+	`create table if not exists url (
+		url      text not null,
+		file_id  text not null,
+
+		primary key(url, file_id)
+	)`,
+	`create table if not exists wikilink (
+		reference text not null,
+		alias    text,
+		file_id  text not null,
+
+		primary key(reference, alias, file_id)
+	)`,
+	`create table if not exists metadata (
+		file_id  text not null,
+		schema   text not null,
+		content  text not null,
+
+		primary key(file_id, content)
+	)`,
+	`create table if not exists heading (
+		heading  text not null,
+		level    integer not null,
+		file_id  text not null,
+
+		primary key(heading, level, file_id)
+	)`,
+	`insert into file (id, basename, title, hash) values ('/vault/a.md', 'a', 'a', '1234')`,
+	`insert into tag (tag, file_id) values ('#a', '/vault/a.md'), ('#b', '/vault/gone.md')`,
+	`insert into url (url, file_id) values ('https://e.com', '/vault/a.md')`,
+	`insert into wikilink (reference, alias, file_id) values ('b', '', '/vault/a.md')`,
+	`insert into metadata (file_id, schema, content) values ('/vault/a.md', '!frontmatter', '{}')`,
+	`insert into heading (heading, level, file_id) values ('A', 1, '/vault/a.md')`,
+}
+
+/*
+ * Open an empty in-memory database. Each connection to :memory: is its own
+ * database, so the pool is kept to one connection.
+ *
+ */
+func openTestDB(t *testing.T) *ObsidianDB {
+	t.Helper()
+
+	conn, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Db.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	return &conn
+}
+
+/*
+ * Describe a database's tables: their columns, with types, defaults and
+ * keys, and their foreign keys
+ *
+ */
+func describeSchema(t *testing.T, conn *ObsidianDB) map[string][]string {
+	t.Helper()
+
+	tables := []string{}
+	rows, err := conn.Db.Query(`
+	select name from sqlite_master where type = 'table' and name not like 'sqlite_%' and name != 'schema_version'
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	schema := map[string][]string{}
+	for _, table := range tables {
+		for _, pragma := range []string{"table_info", "foreign_key_list"} {
+			rows, err := conn.Db.Query(fmt.Sprintf(`pragma %v(%v)`, pragma, table))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			columns, err := rows.Columns()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for rows.Next() {
+				values := make([]interface{}, len(columns))
+				pointers := make([]interface{}, len(columns))
+				for idx := range values {
+					pointers[idx] = &values[idx]
+				}
+
+				if err := rows.Scan(pointers...); err != nil {
+					t.Fatal(err)
+				}
+
+				description := []string{pragma}
+				for _, value := range values {
+					description = append(description, fmt.Sprintf("%v", value))
+				}
+				schema[table] = append(schema[table], strings.Join(description, " "))
+			}
+			rows.Close()
+		}
+	}
+
+	return schema
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	conn := openTestDB(t)
+
+	for _, statement := range baselineSchema {
+		if _, err := conn.Db.Exec(statement); err != nil {
+			t.Fatalf("seeding the baseline schema: %v", err)
+		}
+	}
+
+	if version, err := conn.SchemaVersion(); err != nil || version != 0 {
+		t.Fatalf("SchemaVersion() of the baseline = %v, %v; want 0", version, err)
+	}
+
+	if err := conn.Migrate(); err != nil {
+		t.Fatalf("Migrate(): %v", err)
+	}
+
+	if version, err := conn.SchemaVersion(); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() = %v, %v; want %v", version, err, LatestSchemaVersion())
+	}
+
+	// notes keyed on absolute paths are dropped, to be re-read into the new tables
+	for _, table := range []string{"file", "tag", "url", "wikilink", "metadata", "heading"} {
+		var count int
+		if err := conn.Db.QueryRow(`select count(*) from ` + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("%v has %v rows after migrating; want none", table, count)
+		}
+	}
+
+	// an upgraded database has the same schema as a new one
+	fresh := openTestDB(t)
+	if err := fresh.Migrate(); err != nil {
+		t.Fatalf("Migrate() of a new database: %v", err)
+	}
+
+	got, want := describeSchema(t, conn), describeSchema(t, fresh)
+	if !reflect.DeepEqual(got, want) {
+		for table := range want {
+			if !reflect.DeepEqual(got[table], want[table]) {
+				t.Errorf("table %v after migrating:\n got  %v\n want %v", table, got[table], want[table])
+			}
+		}
+		for table := range got {
+			if _, ok := want[table]; !ok {
+				t.Errorf("table %v is left after migrating", table)
+			}
+		}
+	}
+
+	// migrating again applies nothing
+	if pending, err := conn.PendingMigrations(); err != nil || len(pending) != 0 {
+		t.Errorf("PendingMigrations() after migrating = %v, %v; want none", pending, err)
+	}
+	if err := conn.Migrate(); err != nil {
+		t.Errorf("Migrate() again: %v", err)
+	}
+}
+
+func TestMigrateCascades(t *testing.T) {
+	conn := openTestDB(t)
+	if err := conn.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	statements := []string{
+		`insert into file (id, path, basename, title, hash) values ('id', 'a.md', 'a', 'a', '')`,
+		`insert into tag (tag, file_id, start_offset, end_offset, line, column) values ('#a', 'id', 0, 2, 1, 1)`,
+		`insert into property (file_id, key, type, text) values ('id', 'k', 'text', 'v')`,
+		`delete from file where id = 'id'`,
+	}
+	for _, statement := range statements {
+		if _, err := conn.Db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, table := range []string{"tag", "property"} {
+		var count int
+		if err := conn.Db.QueryRow(`select count(*) from ` + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("%v has %v rows after deleting their file; want none", table, count)
+		}
+	}
+
+	_, err := conn.Db.Exec(`insert into tag (tag, file_id, start_offset, end_offset, line, column) values ('#a', 'missing', 0, 2, 1, 1)`)
+	if err == nil {
+		t.Errorf("inserting a tag for a missing file succeeded; want a foreign key error")
+	}
+}
+
+func TestMigrateForeignKeys(t *testing.T) {
+	conn := openTestDB(t)
+
+	for _, statement := range baselineSchema {
+		if _, err := conn.Db.Exec(statement); err != nil {
+			t.Fatalf("seeding the baseline schema: %v", err)
+		}
+	}
+
+	// migrate up to the step adding foreign keys, while notes are still keyed on absolute paths
+	for _, migration := range MIGRATIONS {
+		if migration.Version > 4 {
+			break
+		}
+		if err := conn.ApplyMigration(migration); err != nil {
+			t.Fatalf("ApplyMigration(%v): %v", migration.Version, err)
+		}
+	}
+
+	// the tag of a note no longer stored is dropped, and the stored note keeps its rows
+	tags := []string{}
+	rows, err := conn.Db.Query(`select tag from tag`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, tag)
+	}
+	rows.Close()
+
+	if !reflect.DeepEqual(tags, []string{"#a"}) {
+		t.Errorf("tags after migration 4 = %v; want only #a, dropping #b of a missing note", tags)
+	}
+
+	// urls and wikilinks were recreated by earlier steps, so are empty
+	statements := []string{
+		`insert into url (url, scheme, host, path, line, file_id) values ('https://e.com', 'https', 'e.com', '', 1, '/vault/a.md')`,
+		`insert into wikilink (reference, alias, file_id, target) values ('b', '', '/vault/a.md', 'b')`,
+	}
+	for _, statement := range statements {
+		if _, err := conn.Db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tables := []string{"tag", "url", "wikilink", "metadata", "heading"}
+	for _, table := range tables {
+		var count int
+		if err := conn.Db.QueryRow(`select count(*) from ` + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("%v has %v rows before deleting their file; want 1", table, count)
+		}
+	}
+
+	if _, err := conn.Db.Exec(`delete from file where id = '/vault/a.md'`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range tables {
+		var count int
+		if err := conn.Db.QueryRow(`select count(*) from ` + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("%v has %v rows after deleting their file; want none", table, count)
+		}
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	conn := openTestDB(t)
+	if err := conn.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := conn.Db.Exec(`insert into schema_version (version, description, applied_at) values (?, 'from the future', '')`,
+		LatestSchemaVersion()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.PendingMigrations(); err == nil {
+		t.Errorf("PendingMigrations() of a newer database succeeded; want an error")
+	}
+	if err := conn.Migrate(); err == nil {
+		t.Errorf("Migrate() of a newer database succeeded; want an error")
+	}
+}
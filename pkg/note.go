@@ -1,17 +1,24 @@
 package diatom
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"hash/fnv"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
+	"unicode"
 
-	"github.com/gernest/front"
 	"github.com/ghodss/yaml"
+	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/ast"
 	"github.com/gomarkdown/markdown/parser"
 )
@@ -39,7 +46,7 @@ func GetSectionBounds(text string) []int {
  *
  */
 func NewNote(fpath string) ObsidianNote {
-	return ObsidianNote{fpath, nil, nil}
+	return ObsidianNote{fpath: fpath, bodyLine: 1}
 }
 
 /*
@@ -63,230 +70,757 @@ func (note *ObsidianNote) Read() (string, error) {
 func (note *ObsidianNote) FindTitle() string {
 	titlePair := regexp.
 		MustCompile("-").
-		Split(strings.Replace(note.fpath, ".md", "", 1), -1)
+		Split(strings.Replace(filepath.Base(note.fpath), ".md", "", 1), -1)
 
 	return titlePair[len(titlePair)-1]
 }
 
 /*
- * Find WikiLinks in a document.
+ * Split a wikilink reference into its target note, heading and block-id.
+ * `Note#Heading`, `Note#^block` and `Note^block` are all accepted; nested
+ * headings (`Note#H1#H2`) keep the innermost heading.
  *
  */
-func FindWikilinks(body string) []*Wikilink {
-	wikilinkPattern := regexp.MustCompile(`\[{2}[^\[]+\]{2}`)
+func ParseReference(ref string) (string, string, string) {
+	target, fragment := ref, ""
 
-	linkText := wikilinkPattern.FindAllString(body, -1)
-	wikilinks := make([]*Wikilink, 0)
+	if idx := strings.Index(ref, "#"); idx >= 0 {
+		target, fragment = ref[:idx], ref[idx+1:]
+	} else if idx := strings.Index(ref, "^"); idx >= 0 {
+		target, fragment = ref[:idx], ref[idx:]
+	}
+
+	target = strings.TrimSpace(target)
 
-	for _, link := range linkText {
-		text := link[2 : len(link)-2]
-		parts := strings.Split(text, "|")
+	if strings.HasPrefix(fragment, "^") {
+		return target, "", strings.TrimSpace(fragment[1:])
+	}
 
-		ref := parts[0]
-		alias := ""
+	headings := strings.Split(fragment, "#")
+	return target, strings.TrimSpace(headings[len(headings)-1]), ""
+}
 
-		if len(parts) > 1 {
-			alias = parts[1]
+/*
+ * The position of a span of source text, as a byte range
+ * relative to the start of the source
+ *
+ */
+func (source Source) Position(start, end int) Position {
+	before := source.Text[:start]
+
+	return Position{
+		Start:  source.Offset + start,
+		End:    source.Offset + end,
+		Line:   source.Line + strings.Count(before, "\n"),
+		Column: start - strings.LastIndex(before, "\n"),
+	}
+}
+
+/*
+ * The bounds of the line containing a byte offset, excluding its line-ending
+ *
+ */
+func lineBounds(text string, idx int) (int, int) {
+	start := strings.LastIndex(text[:idx], "\n") + 1
+
+	end := len(text)
+	if next := strings.Index(text[idx:], "\n"); next >= 0 {
+		end = idx + next
+	}
+
+	return start, start + len(strings.TrimRight(text[start:end], "\r"))
+}
+
+/*
+ * The end of a list item beginning on the line with the given bounds: the end
+ * of the last following line indented past the item's marker, before the first
+ * line that is not
+ *
+ */
+func listItemEnd(text string, start, end int) int {
+	line := text[start:end]
+	indent := len(line) - len(strings.TrimLeft(line, " \t"))
+
+	itemEnd := end
+	for lineEnd := end; ; {
+		newline := strings.Index(text[lineEnd:], "\n")
+		if newline < 0 {
+			break
 		}
 
-		wikilinks = append(wikilinks, &Wikilink{
-			Reference: ref,
-			Alias:     alias,
-		})
+		var lineStart int
+		lineStart, lineEnd = lineBounds(text, lineEnd+newline+1)
+
+		line := text[lineStart:lineEnd]
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if len(line)-len(trimmed) <= indent {
+			break
+		}
+
+		itemEnd = lineEnd
 	}
 
-	return wikilinks
+	return itemEnd
 }
 
-// Find tags
-func FindTags(body string) []string {
-	tagPattern := regexp.MustCompile(`\#[a-zA-Z_\/]+`)
+var wikilinkPattern = regexp.MustCompile(`\[\[([^\[\]]+)\]\]`)
 
-	return tagPattern.FindAllString(body, -1)
+/*
+ * Find the bounds of each wikilink in a run of text
+ *
+ */
+func FindWikilinks(text string) [][]int {
+	return wikilinkPattern.FindAllStringIndex(text, -1)
 }
 
-// Find URLs
-func FindUrls(body string) []string {
-	return []string{}
+/*
+ * Parse a wikilink, brackets included, into its reference and alias
+ *
+ */
+func ParseWikilink(link string) *Wikilink {
+	text := link[2 : len(link)-2]
+	parts := strings.SplitN(text, "|", 2)
+
+	// wikilinks in tables escape their alias pipe
+	ref := strings.TrimSuffix(parts[0], "\\")
+	alias := ""
+
+	if len(parts) > 1 {
+		alias = parts[1]
+	}
+
+	target, heading, blockId := ParseReference(ref)
+
+	return &Wikilink{
+		Reference: ref,
+		Alias:     alias,
+		Target:    target,
+		Heading:   heading,
+		BlockId:   blockId,
+	}
 }
 
+// Obsidian's tag grammar: a # at the start of a line or after whitespace, or opening
+// a ==highlight==, followed by letters, digits, _, - and /. Tags may not be entirely
+// numeric. A run of text may follow emphasis, a link or code, so a # at its start is
+// checked against them.
+var tagPattern = regexp.MustCompile(`(?:^|\s)(?:==)?(#[\p{L}\p{M}\p{N}_/-]+)`)
+
 /*
- * Compute a hash for input text
+ * Find the bounds of each tag in a run of text
+ *
  */
-func HashContent(text string) uint32 {
-	hash := fnv.New32a()
-	hash.Write([]byte(text))
-	return hash.Sum32()
+func FindTags(text string) [][]int {
+	bounds := [][]int{}
+
+	for _, match := range tagPattern.FindAllStringSubmatchIndex(text, -1) {
+		tag := text[match[2]+1 : match[3]]
+
+		if strings.IndexFunc(tag, func(char rune) bool { return !unicode.IsDigit(char) }) < 0 {
+			continue
+		}
+		bounds = append(bounds, match[2:4])
+	}
+
+	return bounds
 }
 
 /*
- * Write notes to Obsidian
+ * Does a node start its line of text, rather than follow another inline element
+ * such as emphasis, code or a link? A node opening emphasis follows only its
+ * delimiter.
  *
  */
-func (note *ObsidianNote) Write(conn *ObsidianDB) <-chan error {
-	fpath := note.fpath
-	errors := make(chan error)
-	bodyData := note.data
+func startsLine(node ast.Node) bool {
+	switch ast.GetPrevNode(node).(type) {
+	case nil, *ast.Softbreak, *ast.Hardbreak:
+		return true
+	}
 
-	if bodyData == nil {
-		return errors
+	return false
+}
+
+/*
+ * Find the first occurrence of a substring, searching from an offset.
+ *
+ */
+func findFrom(text string, substr string, offset int) int {
+	idx := strings.Index(text[offset:], substr)
+	if idx < 0 {
+		return -1
 	}
 
-	// +++ subfunction definitions +++ //
+	return offset + idx
+}
 
-	insertFile := func(wg *sync.WaitGroup, errors chan<- error) {
-		defer wg.Done()
+/*
+ * Find the bracket closing the one at an index, skipping nested brackets and
+ * escaped characters. Returns -1 if it is not closed.
+ *
+ */
+func matchBracket(text string, idx int) int {
+	open := text[idx]
+	close := byte(']')
+	if open == '(' {
+		close = ')'
+	}
 
-		if err := conn.InsertFile(fpath, bodyData.Title, fmt.Sprint(bodyData.Hash)); err != nil {
-			errors <- err
+	depth := 0
+	for jdx := idx; jdx < len(text); jdx++ {
+		switch text[jdx] {
+		case '\\':
+			jdx++
+		case open:
+			depth++
+		case close:
+			if depth--; depth == 0 {
+				return jdx
+			}
 		}
 	}
 
-	insertTags := func(wg *sync.WaitGroup, errors chan<- error) {
-		defer wg.Done()
+	return -1
+}
 
-		if err := conn.InsertTags(bodyData, fpath); err != nil {
-			errors <- err
-		}
+/*
+ * Find the end of a markdown link or image, searching onward from an offset. Links
+ * are written `[label](dest)`, `[label][id]`, `[label][]` or `[label]`, and images
+ * start with `!`; an autolink is its label alone, perhaps in angle brackets.
+ * Returns the offset after the link.
+ *
+ */
+func findLinkEnd(text string, offset int, image bool, label string, autolink bool) (int, bool) {
+	open := "["
+	if image {
+		open = "!["
 	}
 
-	insertUrls := func(wg *sync.WaitGroup, errors chan<- error) {
-		defer wg.Done()
+	bare := -1
+	if autolink {
+		bare = findFrom(text, label, offset)
+	}
 
-		if err := conn.InsertUrl(bodyData, fpath); err != nil {
-			errors <- err
+	for from := offset; ; {
+		start := findFrom(text, open, from)
+		if start < 0 || (bare >= 0 && bare < start) {
+			break
 		}
-	}
+		from = start + len(open)
 
-	insertWikilinks := func(wg *sync.WaitGroup, errors chan<- error) {
-		defer wg.Done()
+		close := matchBracket(text, from-1)
+		if close < 0 {
+			continue
+		}
 
-		if err := conn.InsertWikilinks(bodyData, fpath); err != nil {
-			errors <- err
+		// an inline or reference link; a bracketed label alone must match the link's
+		if next := close + 1; next < len(text) && (text[next] == '(' || text[next] == '[') {
+			if end := matchBracket(text, next); end >= 0 {
+				return end + 1, true
+			}
+		} else if strings.EqualFold(strings.TrimSpace(text[from:close]), strings.TrimSpace(label)) {
+			return close + 1, true
 		}
 	}
 
-	insertFrontmatter := func(wg *sync.WaitGroup, errors chan<- error) {
-		defer wg.Done()
+	if bare < 0 {
+		return offset, false
+	}
 
-		if err := conn.InsertFrontmatter(note.frontMatter, fpath); err != nil {
-			errors <- err
-		}
+	end := bare + len(label)
+	if end < len(text) && text[end] == '>' {
+		end++
 	}
+	return end, true
+}
 
-	insertHeadings := func(wg *sync.WaitGroup, errors chan<- error) {
-		defer wg.Done()
+/*
+ * Find a fenced code-block by its info-string, searching onward from an offset.
+ * Returns the span from the opening fence to the end of the closing fence;
+ * an unclosed block runs to the end of the text.
+ *
+ */
+func findCodeBlock(text string, info string, offset int) (int, int, bool) {
+	for {
+		idx := findFrom(text, info, offset)
+		if idx < 0 {
+			return 0, 0, false
+		}
+		start, end := lineBounds(text, idx)
+		fence := strings.TrimLeft(text[start:end], " \t")
 
-		if err := conn.InsertHeadings(bodyData, fpath); err != nil {
-			errors <- err
+		char := "`"
+		if strings.HasPrefix(fence, "~") {
+			char = "~"
+		}
+		marker := fence[:len(fence)-len(strings.TrimLeft(fence, char))]
+
+		// not a fence, so carry on from the next line
+		if len(marker) < 3 || !strings.HasPrefix(strings.TrimSpace(fence[len(marker):]), info) {
+			next := strings.Index(text[end:], "\n")
+			if next < 0 {
+				return 0, 0, false
+			}
+			offset = end + next + 1
+			continue
+		}
+
+		// the block closes on the first line of at least as many fence characters
+		for {
+			next := strings.Index(text[end:], "\n")
+			if next < 0 {
+				return start, len(strings.TrimRight(text, "\r\n")), true
+			}
+
+			var lineStart int
+			lineStart, end = lineBounds(text, end+next+1)
+			line := strings.TrimLeft(text[lineStart:end], " \t")
+
+			if strings.HasPrefix(line, marker) && strings.Trim(line, char) == "" {
+				return start, end, true
+			}
 		}
 	}
+}
 
-	deleteExisting := func(errors chan<- error) {
-		if err := note.Delete(conn); err != nil {
-			errors <- err
+/*
+ * The anchor slug of a heading, or of the heading named in a wikilink. Obsidian
+ * drops characters that cannot appear in links, such as `:` and `#`, when linking
+ * to a heading, and matches case-insensitively; slugs are compared the same way.
+ *
+ */
+func HeadingSlug(text string) string {
+	cleaned := strings.Map(func(char rune) rune {
+		if strings.ContainsRune("#|^:%[]", char) {
+			return ' '
 		}
+		return char
+	}, text)
+
+	return strings.ToLower(strings.Join(strings.Fields(cleaned), "-"))
+}
+
+// A ^block-id ending a block, after whitespace or alone on its line
+var blockIdPattern = regexp.MustCompile(`(?:^|\s)\^([a-zA-Z0-9-]+)\s*$`)
+
+/*
+ * The ^block-id ending a run of text, if any
+ *
+ */
+func FindBlockId(text string) (string, bool) {
+	match := blockIdPattern.FindStringSubmatch(text)
+	if match == nil {
+		return "", false
+	}
+
+	return match[1], true
+}
+
+/*
+ * Find the span of a block marked with a ^block-id, searching onward from an
+ * offset. The block runs back from the marker to a blank line; a list item
+ * starts at its list marker, and a marker alone on its line marks the block
+ * before it.
+ *
+ */
+func findBlock(text string, id string, blockType string, offset int) (int, int, bool) {
+	pattern := regexp.MustCompile(`(?m)(?:^|[ \t])\^` + regexp.QuoteMeta(id) + `[ \t]*\r?$`)
+
+	bounds := pattern.FindStringIndex(text[offset:])
+	if bounds == nil {
+		return 0, 0, false
 	}
 
-	// +++ function core +++ //
+	markerStart, end := offset+bounds[0], offset+bounds[1]
+	end = len(strings.TrimRight(text[:end], "\r"))
 
-	// delete existing entries, and replace entries
-	go func() {
-		defer close(errors)
+	lineStart, _ := lineBounds(text, markerStart)
+	start := lineStart
 
-		deleteExisting(errors)
+	isBlank := func(line string) bool { return strings.TrimSpace(line) == "" }
+	listMarker := regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s`)
 
-		var wg sync.WaitGroup
-		wg.Add(6)
+	// a marker alone on its line follows the block, after any blank lines
+	if isBlank(text[lineStart:markerStart]) && blockType != BLOCK_PARAGRAPH && blockType != BLOCK_LIST_ITEM {
+		for start > 0 {
+			prevStart, prevEnd := lineBounds(text, start-1)
+			if !isBlank(text[prevStart:prevEnd]) {
+				break
+			}
+			start = prevStart
+		}
+	}
 
-		go insertFile(&wg, errors)
-		go insertTags(&wg, errors)
-		go insertUrls(&wg, errors)
-		go insertWikilinks(&wg, errors)
-		go insertFrontmatter(&wg, errors)
-		go insertHeadings(&wg, errors)
+	for start > 0 {
+		if blockType == BLOCK_LIST_ITEM && listMarker.MatchString(text[start:]) {
+			break
+		}
 
-		wg.Wait()
-	}()
+		prevStart, prevEnd := lineBounds(text, start-1)
+		prev := text[prevStart:prevEnd]
 
-	return errors
+		if isBlank(prev) || (blockType == BLOCK_PARAGRAPH && strings.HasPrefix(strings.TrimSpace(prev), "#")) {
+			break
+		}
+		start = prevStart
+	}
+
+	return start, end, true
 }
 
 /*
- * Get the hash currently recorded for a file
+ * Find a heading's line, searching onward from an offset. ATX headings open
+ * with a # per level; setext headings are underlined with = or -. Only the
+ * heading's first text is known from the AST, and a line containing it is
+ * preferred over any other heading of the same level.
+ *
  */
-func (note *ObsidianNote) GetStoredHash(conn *ObsidianDB) (string, error) {
-	return conn.GetFileHash(note.fpath)
+func findHeading(text string, level int, title string, offset int) (int, int, bool) {
+	atx := strings.Repeat("#", level)
+
+	// start from the line after the offset, unless it begins a line
+	if offset > 0 && text[offset-1] != '\n' {
+		next := strings.Index(text[offset:], "\n")
+		if next < 0 {
+			return 0, 0, false
+		}
+		offset += next + 1
+	}
+
+	for _, wanted := range []string{title, ""} {
+		for pos := offset; pos <= len(text); {
+			start, end := lineBounds(text, pos)
+			line := text[start:end]
+			trimmed := strings.TrimLeft(line, " ")
+
+			isHeading := strings.HasPrefix(trimmed, atx) &&
+				(len(trimmed) == level || trimmed[level] == ' ' || trimmed[level] == '\t')
+
+			next := strings.Index(text[end:], "\n")
+			if !isHeading && next >= 0 && level <= 2 && strings.TrimSpace(line) != "" {
+				_, underlineEnd := lineBounds(text, end+next+1)
+				underline := strings.TrimSpace(text[end+next+1 : underlineEnd])
+				char := "="
+				if level == 2 {
+					char = "-"
+				}
+
+				isHeading = underline != "" && strings.Trim(underline, char) == ""
+			}
+
+			if isHeading && strings.Contains(line, wanted) {
+				return start, end, true
+			}
+
+			if next < 0 {
+				break
+			}
+			pos = end + next + 1
+		}
+	}
+
+	return 0, 0, false
 }
 
-func (note *ObsidianNote) Changed(text string, conn *ObsidianDB) (bool, error) {
-	hash, err := note.GetStoredHash(conn)
+/*
+ * Divide a note into sections. Each heading at the top level of the note opens a
+ * section that runs until the next heading of the same or a higher level, so it
+ * holds its subsections. Text before the first heading is in no section.
+ *
+ */
+func ReadSections(doc ast.Node, positions map[*ast.Heading]Position, source Source) []Section {
+	sections := []Section{}
+	headings := []*ast.Heading{}
+	bodies := []*strings.Builder{}
+
+	// the indices of sections containing the current node, outermost first
+	open := []int{}
 
-	if err != nil {
-		return false, err
+	write := func(text string) {
+		if text == "" {
+			return
+		}
+		for _, idx := range open {
+			if bodies[idx].Len() > 0 {
+				bodies[idx].WriteString("\n")
+			}
+			bodies[idx].WriteString(text)
+		}
 	}
 
-	currHash := HashContent(text)
-	if hash == fmt.Sprint(currHash) {
-		return false, nil
+	for _, child := range doc.GetChildren() {
+		heading, ok := child.(*ast.Heading)
+		if !ok {
+			write(PlainText(child))
+			continue
+		}
+
+		for len(open) > 0 && sections[open[len(open)-1]].Level >= heading.Level {
+			open = open[:len(open)-1]
+		}
+
+		text := PlainText(heading)
+		write(text)
+
+		parentId := 0
+		if len(open) > 0 {
+			parentId = sections[open[len(open)-1]].Id
+		}
+
+		sections = append(sections, Section{
+			Id:       len(sections) + 1,
+			ParentId: parentId,
+			Level:    heading.Level,
+			Heading:  text,
+		})
+		headings = append(headings, heading)
+		bodies = append(bodies, &strings.Builder{})
+		open = append(open, len(sections)-1)
+	}
+
+	for idx := range sections {
+		sections[idx].Text = bodies[idx].String()
+
+		start := positions[headings[idx]]
+		if start.Line == 0 {
+			continue
+		}
+
+		// end where the next heading of the same or a higher level starts; when
+		// that heading was not located, the span is unknown
+		end := len(source.Text)
+		for _, next := range sections[idx+1:] {
+			if next.Level <= sections[idx].Level {
+				end = positions[headings[next.Id-1]].Start - source.Offset
+				break
+			}
+		}
+
+		if end < start.Start-source.Offset {
+			continue
+		}
+
+		sections[idx].Position = source.Position(start.Start-source.Offset, end)
 	}
 
-	return true, nil
+	return sections
 }
 
 /*
- * Extract information about a note
+ * Read a URL from a link-node. Bare URLs, autolinks and markdown links
+ * are all link-nodes in the AST; local links without a scheme are ignored.
+ *
  */
-func (note *ObsidianNote) ExtractData(conn *ObsidianDB) (bool, error) {
-	text, err := note.Read()
-
-	if err != nil {
-		return false, err
+func ParseUrl(link *ast.Link) (*Url, bool) {
+	if link.NoteID != 0 {
+		return nil, false
 	}
 
-	changed, err := note.Changed(text, conn)
-	if err != nil {
-		return false, err
+	dest := string(link.Destination)
+	parsed, err := url.Parse(dest)
+	if err != nil || parsed.Scheme == "" {
+		return nil, false
 	}
 
-	if !changed {
-		return true, nil
+	text := ""
+	for _, child := range link.Children {
+		if leaf := child.AsLeaf(); leaf != nil {
+			text += string(leaf.Literal)
+		}
 	}
 
-	// proceed, and process the note further
+	return &Url{
+		Url:    dest,
+		Scheme: strings.ToLower(parsed.Scheme),
+		Host:   strings.ToLower(parsed.Hostname()),
+		Path:   parsed.Path,
+		Text:   text,
+	}, true
+}
 
-	matter := front.NewMatter()
-	matter.Handle("---", front.YAMLHandler)
+/*
+ * Compute a SHA-256 hash for input text, hex-encoded
+ */
+func HashContent(text string) string {
+	hash := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(hash[:])
+}
 
-	// read frontmatter and body
-	frontMatter, _, err := matter.Parse(strings.NewReader(text))
+/*
+ * Generate a note id from its vault-relative path. Ids are name-based (version 5)
+ * UUIDs, so the same vault indexed on two machines gets the same ids; a renamed
+ * note keeps the id of its first path, which a fresh index does not know.
+ */
+func GenerateNoteId(rel string) string {
+	hash := sha1.New()
+	hash.Write(NOTE_ID_NAMESPACE[:])
+	hash.Write([]byte(rel))
 
-	if err != nil {
-		note.frontMatter = map[string]interface{}{}
-		return false, nil
+	id := hash.Sum(nil)[:16]
+	id[6] = (id[6] & 0x0f) | 0x50
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+/*
+ * Has a note changed since its hash was stored?
+ */
+func (note *ObsidianNote) Changed(text string, storedHash string) bool {
+	return storedHash != HashContent(text)
+}
+
+/*
+ * Extract information about a note
+ */
+func (note *ObsidianNote) ExtractData(text string, cfg *DiatomConfig) error {
+	note.body = text
+
+	if note.data == nil {
+		note.data = &MarkdownData{}
 	}
+	note.data.Hash = HashContent(text)
 
-	note.frontMatter = frontMatter
+	note.frontMatter = map[string]interface{}{}
+	note.data.Title = note.FindTitle()
 
-	body := ""
+	body := text
+	bodyLine := 1
+	bodyOffset := 0
 	bounds := GetSectionBounds(text)
 
-	if len(bounds) > 0 {
+	// frontmatter must open on the first line, and be closed
+	hasFrontmatter := len(bounds) > 1 && bounds[0] == 0
+	if !hasFrontmatter && len(bounds) == 1 && bounds[0] == 0 {
+		note.AddDiagnostic(1, SEVERITY_WARNING, EXTRACTOR_FRONTMATTER, "frontmatter is not closed with ---")
+	}
+
+	if hasFrontmatter {
 		// get the text after the section bounds
-		body = text[bounds[1]:]
+		lines := strings.SplitAfter(text, "\n")
+		bodyLine = bounds[1] + 2
+		bodyOffset = len(strings.Join(lines[:bounds[1]+1], ""))
+		body = text[bodyOffset:]
+
+		frontmatterEnd := bodyOffset - len(lines[bounds[1]]) + len(strings.TrimRight(lines[bounds[1]], "\r\n"))
+		note.data.FrontmatterPosition = Position{Start: 0, End: frontmatterEnd, Line: 1, Column: 1}
+
+		// read frontmatter once, as YAML 1.2; metadata, fields and properties are
+		// all derived from it. Invalid yaml is reported, and the note read without it
+		frontmatterYaml := strings.Join(lines[1:bounds[1]], "")
+		values, err := DecodeFrontmatter(frontmatterYaml)
+
+		if err != nil {
+			note.AddDiagnostic(1+yamlErrorLine(err), SEVERITY_ERROR, EXTRACTOR_FRONTMATTER, err.Error())
+		} else if cfg.Enabled(EXTRACTOR_FRONTMATTER) {
+			note.frontMatter = values
+
+			bytes, err := json.Marshal(values)
+			if err != nil {
+				return err
+			}
+
+			note.data.Frontmatter = string(bytes)
+
+			if cfg.Enabled(EXTRACTOR_FIELDS) {
+				fields, err := FrontmatterFields(values, text[:frontmatterEnd])
+				if err != nil {
+					return err
+				}
+				note.data.Fields = fields
+			}
+		}
 	}
 
+	note.body = body
+	note.bodyLine = bodyLine
+	note.bodyOffset = bodyOffset
+
+	return nil
+}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+/*
+ * The line a yaml error occurred on, counting from one; errors without
+ * a line are reported against the first line
+ */
+func yamlErrorLine(err error) int {
+	match := yamlLinePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 1
+	}
+
+	line, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 1
+	}
+
+	return line
+}
+
+/*
+ * The note body, after any frontmatter, and where it starts in the file
+ */
+func (note *ObsidianNote) Source() Source {
+	return Source{Text: note.body, Offset: note.bodyOffset, Line: note.bodyLine}
+}
+
+/*
+ * Record a problem found while extracting a note
+ */
+func (note *ObsidianNote) AddDiagnostic(line int, severity, extractor, message string) {
 	if note.data == nil {
 		note.data = &MarkdownData{}
 	}
 
-	note.data.Title = note.FindTitle()
-	note.data.Wikilinks = FindWikilinks(body)
-	note.data.Tags = FindTags(body)
-	note.data.Urls = FindUrls(body)
-	note.data.Hash = HashContent(text)
+	note.data.Diagnostics = append(note.data.Diagnostics, Diagnostic{
+		Line:      line,
+		Severity:  severity,
+		Extractor: extractor,
+		Message:   message,
+	})
+}
+
+/*
+ * The note's `id` frontmatter property, if present
+ */
+func (note *ObsidianNote) FrontmatterId() string {
+	return frontmatterIdText(note.frontMatter["id"])
+}
+
+/*
+ * The text of a frontmatter `id` value; numbers are written
+ * without an exponent, so large numeric ids stay readable
+ */
+func frontmatterIdText(id interface{}) string {
+	switch id := id.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(id)
+}
 
-	return false, nil
+/*
+ * The data extracted from a note, for the writer, stored under its
+ * vault-relative path. The note must not be modified afterwards.
+ */
+func (note *ObsidianNote) Result(rel string) *NoteResult {
+	data := note.data
+	if data == nil {
+		data = &MarkdownData{}
+	}
+
+	return &NoteResult{
+		Path:          rel,
+		Data:          data,
+		FrontmatterId: note.FrontmatterId(),
+	}
 }
 
 /*
@@ -301,20 +835,73 @@ func (note *ObsidianNote) SetHeadings(headings []Heading) {
 }
 
 /*
- * Read and parse note content as markdown
+ * Set URL data
  */
-func (note *ObsidianNote) Parse() (ast.Node, error) {
-	content, err := os.ReadFile(note.fpath)
-	if err != nil {
-		return nil, err
+func (note *ObsidianNote) SetUrls(urls []*Url) {
+	if note.data == nil {
+		note.data = &MarkdownData{}
 	}
 
-	return parser.New().Parse(content), nil
+	note.data.Urls = urls
 }
 
-type Heading struct {
-	Level int
-	Text  string
+/*
+ * Set metadata from labelled code-blocks
+ */
+func (note *ObsidianNote) SetMetadata(metadata []Metadata) {
+	if note.data == nil {
+		note.data = &MarkdownData{}
+	}
+
+	note.data.Metadata = metadata
+}
+
+/*
+ * Parse note content as markdown. The parser reads only \n line-endings, and
+ * reads an unclosed code-block as text, so both are fixed first; entities are
+ * located in the unchanged source.
+ */
+func (note *ObsidianNote) Parse(content []byte) ast.Node {
+	content = markdown.NormalizeNewlines(content)
+	return parser.New().Parse(closeFences(content))
+}
+
+/*
+ * Close a fenced code-block left open at the end of some markdown. Obsidian
+ * shows the rest of the note as code.
+ *
+ */
+func closeFences(content []byte) []byte {
+	marker := ""
+
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if marker != "" {
+			if strings.HasPrefix(trimmed, marker) && strings.Trim(trimmed, marker[:1]) == "" {
+				marker = ""
+			}
+			continue
+		}
+
+		for _, char := range []string{"`", "~"} {
+			fence := trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, char))]
+
+			// backtick fences may not have backticks in their info-string
+			if len(fence) >= 3 && !(char == "`" && strings.Contains(trimmed[len(fence):], "`")) {
+				marker = fence
+			}
+		}
+	}
+
+	if marker == "" {
+		return content
+	}
+
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	return append(content, marker+"\n"...)
 }
 
 func yamlToJson(src string) (string, error) {
@@ -328,98 +915,486 @@ func yamlToJson(src string) (string, error) {
 }
 
 /*
- * Walk through note and collect interesting information
+ * Walk through the note body, after any frontmatter, and collect interesting information.
+ * Wikilinks and tags are read from text-nodes, so code, html and link text are ignored.
+ * Labelled code-blocks with invalid yaml are reported as diagnostics, and skipped.
  *
  */
-func (note *ObsidianNote) Walk(conn *ObsidianDB) <-chan error {
-	errChan := make(chan error)
-	doc, err := note.Parse()
+func (note *ObsidianNote) Walk(cfg *DiatomConfig) error {
+	content := []byte(note.body)
+	doc := note.Parse(content)
+	source := note.Source()
+
+	wikilinks := []*Wikilink{}
+	tags := []Tag{}
+	urls := []*Url{}
+	metadata := []Metadata{}
+	headings := []Heading{}
+	blocks := []Block{}
+	headingPositions := map[*ast.Heading]Position{}
+	tasks := []Task{}
+
+	// the ids of the tasks containing the current node, innermost last; a task's
+	// list item and first paragraph are mapped to its id
+	openTasks := []int{}
+	taskNodes := map[ast.Node]int{}
+	currentTask := 0
+
+	// the byte range of each located task's whole list item, by task id
+	taskSpans := map[int][2]int{}
+
+	// fenced code-blocks, whose lines hold no inline fields
+	codeSpans := [][2]int{}
+
+	// the AST has no positions, so each entity is found by searching the source onward
+	// from the last one found; text, code and html share a cursor, as they share lines
+	offset, urlOffset, headingOffset, blockOffset := 0, 0, 0, 0
+
+	// locate the first of several spellings of some text, and move past it
+	locate := func(spellings ...string) Position {
+		for _, spelling := range spellings {
+			if start := findFrom(source.Text, spelling, offset); start >= 0 {
+				offset = start + len(spelling)
+				return source.Position(start, offset)
+			}
+		}
 
-	if err != nil {
-		errChan <- err
-		return errChan
+		return Position{}
 	}
 
-	tx, err := conn.Db.Begin()
-	if err != nil {
-		errChan <- err
+	// move past literal text, such as inline code, that is not searched for entities
+	skipLiteral := func(literal []byte) {
+		for _, line := range strings.Split(string(literal), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				locate(line)
+			}
+		}
 	}
 
-	// Read code-blocks from the document
-	readCodeBlock := func(node ast.Node) ast.WalkStatus {
-		leaf := node.AsLeaf()
+	// read wikilinks and tags from a run of adjacent text-nodes; the parser splits
+	// text at escaped characters, such as the pipe in a table's wikilink
+	readText := func(node *ast.Text) ast.WalkStatus {
+		if prev, ok := ast.GetPrevNode(node).(*ast.Text); ok && prev != nil {
+			return ast.GoToNext
+		}
 
-		info := string(node.(*ast.CodeBlock).Info)
+		run := ""
+		for next := ast.Node(node); next != nil; next = ast.GetNextNode(next) {
+			text, ok := next.(*ast.Text)
+			if !ok {
+				break
+			}
+			run += string(text.Literal)
+		}
 
-		// -- a special code-block containing application-readable data
-		if isLabelledCodeBlock := len(info) > 0 && info[0] == '!'; isLabelledCodeBlock {
-			content := string(leaf.Literal)
-			json, err := yamlToJson(content)
+		type match struct {
+			bounds   []int
+			wikilink bool
+		}
 
-			if err != nil {
-				errChan <- errors.New(note.fpath + "\n" + err.Error() + "\n" + content)
+		matches := []match{}
+		linkBounds := FindWikilinks(run)
+		if cfg.Enabled(EXTRACTOR_WIKILINKS) {
+			for _, bounds := range linkBounds {
+				matches = append(matches, match{bounds, true})
+			}
+		}
 
-				return ast.GoToNext
+		// a # within a wikilink is part of its reference or alias
+		inWikilink := func(idx int) bool {
+			for _, bounds := range linkBounds {
+				if idx >= bounds[0] && idx < bounds[1] {
+					return true
+				}
 			}
+			return false
+		}
 
-			if err := conn.InsertMetadata(tx, note.fpath, info, json); err != nil {
-				errChan <- err
-				return ast.GoToNext
+		if cfg.Enabled(EXTRACTOR_TAGS) {
+			for _, bounds := range FindTags(run) {
+				if !inWikilink(bounds[0]) {
+					matches = append(matches, match{bounds, false})
+				}
+			}
+		}
+
+		// locate matches in the order they occur
+		sort.Slice(matches, func(idx, jdx int) bool {
+			return matches[idx].bounds[0] < matches[jdx].bounds[0]
+		})
+
+		for _, match := range matches {
+			raw := run[match.bounds[0]:match.bounds[1]]
+
+			if !match.wikilink {
+				position := locate(raw)
+
+				// a run may start straight after another inline element, as in *em*#tag, but
+				// not after the delimiter opening one, as in **#tag**
+				if strings.TrimPrefix(run[:match.bounds[0]], "==") == "" && !startsLine(node) {
+					continue
+				}
+
+				tags = append(tags, Tag{Tag: raw, TaskId: currentTask, Position: position})
+				continue
+			}
+
+			wikilink := ParseWikilink(raw)
+			if wikilink.Heading != "" {
+				wikilink.HeadingSlug = HeadingSlug(wikilink.Heading)
+			}
+			wikilink.IsEmbed = match.bounds[0] > 0 && run[match.bounds[0]-1] == '!'
+			wikilink.Type = WIKILINK_LINK
+			wikilink.TaskId = currentTask
+
+			// embeds show a note, or section of one, unless they name an attachment
+			if _, ok := AttachmentType(wikilink.Target); ok && wikilink.IsEmbed {
+				wikilink.Type = WIKILINK_ATTACHMENT_EMBED
+			} else if wikilink.IsEmbed {
+				wikilink.Type = WIKILINK_NOTE_EMBED
 			}
+			wikilink.Position = locate(raw, strings.Replace(raw, "|", "\\|", 1))
+
+			wikilinks = append(wikilinks, wikilink)
 		}
+
 		return ast.GoToNext
 	}
 
-	headings := []Heading{}
+	// move past a link or image, whose text is not searched for wikilinks or tags
+	skipLink := func(node ast.Node, destination []byte, image bool) {
+		label := ""
+		for _, child := range node.GetChildren() {
+			if leaf := child.AsLeaf(); leaf != nil {
+				label += string(leaf.Literal)
+			}
+		}
+
+		// autolinks have their destination as their only text
+		dest := string(destination)
+		autolink := !image && len(node.GetChildren()) == 1 && (dest == label || dest == "mailto:"+label)
+
+		if end, ok := findLinkEnd(source.Text, offset, image, label, autolink); ok {
+			offset = end
+		}
+	}
+
+	// read URLs from links; link text is not searched for wikilinks or tags
+	readLink := func(link *ast.Link) ast.WalkStatus {
+		// footnotes are written apart from their text
+		if link.NoteID == 0 {
+			skipLink(link, link.Destination, false)
+		}
+
+		url, ok := ParseUrl(link)
+		if !ok || !cfg.Enabled(EXTRACTOR_URLS) {
+			return ast.SkipChildren
+		}
+
+		// fall back to searching from the start, so an unusual link is still positioned
+		start := findFrom(source.Text, url.Url, urlOffset)
+		if start < 0 {
+			start = findFrom(source.Text, url.Url, 0)
+		} else {
+			urlOffset = start + len(url.Url)
+		}
+
+		if start >= 0 {
+			url.Position = source.Position(start, start+len(url.Url))
+		}
+
+		urls = append(urls, url)
+		return ast.SkipChildren
+	}
+
+	// Read code-blocks from the document
+	readCodeBlock := func(block *ast.CodeBlock) ast.WalkStatus {
+		info := string(block.Info)
+
+		position := Position{}
+		fenceLine := note.bodyLine - 1
+
+		if !block.IsFenced {
+			skipLiteral(block.Literal)
+		} else if start, end, ok := findCodeBlock(source.Text, info, offset); ok {
+			position = source.Position(start, end)
+			fenceLine = position.Line
+			offset = end
+			codeSpans = append(codeSpans, [2]int{start, end})
+		}
+
+		// -- a special code-block containing application-readable data
+		if isLabelledCodeBlock := len(info) > 0 && info[0] == '!'; isLabelledCodeBlock && cfg.Enabled(EXTRACTOR_METADATA) {
+			json, err := yamlToJson(string(block.Literal))
+
+			if err != nil {
+				line := fenceLine + yamlErrorLine(err)
+				note.AddDiagnostic(line, SEVERITY_ERROR, EXTRACTOR_METADATA, fmt.Sprintf("invalid yaml in %v code-block: %v", info, err))
+				return ast.GoToNext
+			}
+
+			metadata = append(metadata, Metadata{
+				Schema:   info,
+				Content:  json,
+				Position: position,
+			})
+		}
+		return ast.GoToNext
+	}
 
 	// read headings from the document
-	readHeading := func(node ast.Node) ast.WalkStatus {
-		heading := node.(*ast.Heading)
+	readHeading := func(heading *ast.Heading) ast.WalkStatus {
+		first := ""
+		if len(heading.Children) > 0 {
+			if leaf := heading.Children[0].AsLeaf(); leaf != nil {
+				first = string(leaf.Literal)
+			}
+		}
+
+		// headings are visited in document order, so search onward from the previous one
+		position := Position{}
+		if start, end, ok := findHeading(source.Text, heading.Level, first, headingOffset); ok {
+			position = source.Position(start, end)
+			headingOffset = end
+		}
+		headingPositions[heading] = position
+
+		if !cfg.Enabled(EXTRACTOR_HEADINGS) {
+			return ast.GoToNext
+		}
 
-		child := heading.Children[0]
-		text := string(child.AsLeaf().Literal)
+		text := PlainText(heading)
 
 		headings = append(headings, Heading{
-			Level: heading.Level,
-			Text:  text,
+			Level:    heading.Level,
+			Text:     text,
+			Slug:     HeadingSlug(text),
+			Position: position,
 		})
 
 		return ast.GoToNext
 	}
 
+	// read blocks ending in a ^block-id. A list item or quote is marked in its
+	// text; a table, list, quote or code-block by a paragraph holding only the marker
+	readBlock := func(paragraph *ast.Paragraph) {
+		children := paragraph.Children
+		if len(children) == 0 {
+			return
+		}
+
+		last, ok := children[len(children)-1].(*ast.Text)
+		if !ok {
+			return
+		}
+
+		id, ok := FindBlockId(string(last.Literal))
+		if !ok {
+			return
+		}
+
+		var block ast.Node = paragraph
+		blockType := BLOCK_PARAGRAPH
+
+		if len(children) == 1 && strings.TrimSpace(string(last.Literal)) == "^"+id {
+			switch prev := ast.GetPrevNode(paragraph).(type) {
+			case *ast.Table:
+				block, blockType = prev, BLOCK_TABLE
+			case *ast.List:
+				block, blockType = prev, BLOCK_LIST
+			case *ast.BlockQuote:
+				block, blockType = prev, BLOCK_QUOTE
+			case *ast.CodeBlock:
+				block, blockType = prev, BLOCK_CODE
+			default:
+				return
+			}
+		} else {
+			switch parent := paragraph.GetParent().(type) {
+			case *ast.ListItem:
+				block, blockType = parent, BLOCK_LIST_ITEM
+			case *ast.BlockQuote:
+				block, blockType = parent, BLOCK_QUOTE
+			}
+		}
+
+		position := Position{}
+		if start, end, ok := findBlock(source.Text, id, blockType, blockOffset); ok {
+			position = source.Position(start, end)
+			blockOffset = end
+		}
+
+		marker := regexp.MustCompile(`[ \t]*\^` + regexp.QuoteMeta(id))
+		text := marker.ReplaceAllString(PlainText(block), "")
+
+		blocks = append(blocks, Block{
+			Id:       id,
+			Type:     blockType,
+			Text:     strings.TrimSpace(text),
+			Position: position,
+		})
+	}
+
+	// read list items starting with a checkbox. Only the first line of a task is
+	// its text, as in the Tasks plugin, and its parent is the closest enclosing task
+	readTask := func(item *ast.ListItem) {
+		paragraph, ok := ast.GetFirstChild(item).(*ast.Paragraph)
+		if !ok {
+			return
+		}
+
+		first, ok := ast.GetFirstChild(paragraph).(*ast.Text)
+		if !ok {
+			return
+		}
+
+		prefix := taskPrefixPattern.FindSubmatch(first.Literal)
+		if prefix == nil {
+			return
+		}
+		status := string(prefix[1])
+
+		// search from the line after the offset, unless it begins a line
+		from := offset
+		if from > 0 && source.Text[from-1] != '\n' {
+			if next := strings.Index(source.Text[from:], "\n"); next >= 0 {
+				from += next + 1
+			} else {
+				from = len(source.Text)
+			}
+		}
+
+		position := Position{}
+		itemEnd := 0
+		text := strings.SplitN(PlainText(paragraph), "\n", 2)[0]
+		text = strings.TrimSpace(taskPrefixPattern.ReplaceAllString(text, ""))
+
+		for _, match := range taskLinePattern.FindAllStringSubmatchIndex(source.Text[from:], -1) {
+			if source.Text[from+match[2]:from+match[3]] != status {
+				continue
+			}
+
+			start, end := lineBounds(source.Text, from+match[0])
+			position = source.Position(start, end)
+			itemEnd = source.Offset + listItemEnd(source.Text, start, end)
+			offset = from + match[3] + len("]")
+
+			text = ""
+			if match[4] >= 0 {
+				text = source.Text[from+match[4] : from+match[5]]
+			}
+			break
+		}
+
+		task := ParseTask(status, text)
+		task.Id = len(tasks) + 1
+		if len(openTasks) > 0 {
+			task.ParentId = openTasks[len(openTasks)-1]
+		}
+		task.Position = position
+		if task.Line > 0 {
+			taskSpans[task.Id] = [2]int{task.Start, itemEnd}
+		}
+
+		tasks = append(tasks, task)
+		openTasks = append(openTasks, task.Id)
+		taskNodes[item] = task.Id
+		taskNodes[paragraph] = task.Id
+	}
+
 	// traverse markdown document using this walk function
 	processMarkdownNode := func(node ast.Node, entering bool) ast.WalkStatus {
-		if err != nil {
-			errChan <- err
+		if !entering {
+			if _, ok := taskNodes[node]; ok {
+				switch node.(type) {
+				case *ast.ListItem:
+					openTasks = openTasks[:len(openTasks)-1]
+				case *ast.Paragraph:
+					currentTask = 0
+				}
+			}
+			return ast.GoToNext
 		}
 
 		// parse through markdown document
-		switch node.(type) {
+		switch node := node.(type) {
+		case *ast.Text:
+			return readText(node)
+		case *ast.Link:
+			return readLink(node)
+		case *ast.Image:
+			skipLink(node, node.Destination, true)
+			return ast.SkipChildren
+		case *ast.Code:
+			skipLiteral(node.Literal)
+		case *ast.HTMLSpan:
+			skipLiteral(node.Literal)
+		case *ast.HTMLBlock:
+			skipLiteral(node.Literal)
 		case *ast.CodeBlock:
 			return readCodeBlock(node)
 		case *ast.Heading:
-			if entering {
+			if cfg.Enabled(EXTRACTOR_HEADINGS) || cfg.Enabled(EXTRACTOR_SECTIONS) {
 				return readHeading(node)
 			}
+		case *ast.ListItem:
+			if cfg.Enabled(EXTRACTOR_TASKS) {
+				readTask(node)
+			}
+		case *ast.Paragraph:
+			if id, ok := taskNodes[node]; ok {
+				currentTask = id
+			}
+			if cfg.Enabled(EXTRACTOR_BLOCKS) {
+				readBlock(node)
+			}
 		}
 
 		return ast.GoToNext
 	}
 
-	// walk through markdown tree and store information about each note into a database
-	go func() {
-		defer close(errChan)
+	// walk through the markdown tree and collect information about the note
+	ast.WalkFunc(doc, processMarkdownNode)
 
-		ast.WalkFunc(doc, processMarkdownNode)
-		note.SetHeadings(headings)
+	if note.data == nil {
+		note.data = &MarkdownData{}
+	}
+
+	note.data.Wikilinks = wikilinks
+	note.data.Tags = tags
+	note.SetUrls(urls)
+	note.SetHeadings(headings)
+	note.data.Blocks = blocks
+	if cfg.Enabled(EXTRACTOR_SECTIONS) {
+		note.data.Sections = ReadSections(doc, headingPositions, source)
+	}
+	note.data.Tasks = tasks
+
+	if cfg.Enabled(EXTRACTOR_FIELDS) {
+		for _, field := range ReadInlineFields(source, codeSpans) {
+			// sections nest in id order, so the last holding a field is the innermost
+			for _, section := range note.data.Sections {
+				if section.Line > 0 && field.Start >= section.Start && field.Start < section.End {
+					field.SectionId = section.Id
+				}
+			}
+
+			// tasks nest in id order too, and a field on a task's continuation lines is its own
+			for _, task := range tasks {
+				if span, ok := taskSpans[task.Id]; ok && field.Start >= span[0] && field.Start < span[1] {
+					field.TaskId = task.Id
+				}
+			}
 
-		// commit changes after walk is complete
-		if err = tx.Commit(); err != nil {
-			errChan <- err
+			note.data.Fields = append(note.data.Fields, field)
 		}
-	}()
+	}
+	note.SetMetadata(metadata)
+	if cfg.Enabled(EXTRACTOR_TEXT) {
+		note.data.Text = PlainText(doc)
+	}
 
-	return errChan
+	return nil
 }
 
 /*
@@ -438,28 +1413,3 @@ func (note *ObsidianNote) Exists() (bool, error) {
 
 	return false, err
 }
-
-/*
- * Remove references to non-existing files from the database
- *
- */
-func (note *ObsidianNote) Delete(conn *ObsidianDB) error {
-	err := conn.DeleteWikilink(note.fpath)
-	if err != nil {
-		return err
-	}
-	err = conn.DeleteTag(note.fpath)
-	if err != nil {
-		return err
-	}
-	err = conn.DeleteMetadata(note.fpath)
-	if err != nil {
-		return err
-	}
-	err = conn.DeleteFile(note.fpath)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,598 @@
+package diatom
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// An entity found in a note: the source text its position spans, and where it starts
+type located struct {
+	Kind   string
+	Text   string
+	Line   int
+	Column int
+}
+
+/*
+ * Extract a note's entities with the default configuration, in
+ * the order they occur
+ *
+ */
+func extractLocated(t *testing.T, text string) []located {
+	t.Helper()
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	note := NewNote("note.md")
+	if err := note.ExtractData(text, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := note.Walk(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data := note.data
+	found := []located{}
+	starts := []int{}
+
+	add := func(kind string, position Position) {
+		found = append(found, located{kind, text[position.Start:position.End], position.Line, position.Column})
+		starts = append(starts, position.Start)
+	}
+
+	for _, tag := range data.Tags {
+		add("tag", tag.Position)
+	}
+	for _, wikilink := range data.Wikilinks {
+		add("wikilink", wikilink.Position)
+	}
+	for _, url := range data.Urls {
+		add("url", url.Position)
+	}
+	for _, heading := range data.Headings {
+		add("heading", heading.Position)
+	}
+	for _, block := range data.Blocks {
+		add("block", block.Position)
+	}
+	for _, task := range data.Tasks {
+		add("task", task.Position)
+	}
+	for _, metadata := range data.Metadata {
+		add("metadata", metadata.Position)
+	}
+
+	indices := make([]int, len(found))
+	for idx := range indices {
+		indices[idx] = idx
+	}
+	sort.SliceStable(indices, func(idx, jdx int) bool {
+		return starts[indices[idx]] < starts[indices[jdx]]
+	})
+
+	sorted := []located{}
+	for _, idx := range indices {
+		sorted = append(sorted, found[idx])
+	}
+
+	return sorted
+}
+
+func TestWalkPositions(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want []located
+	}{
+		{
+			name: "repeated entities",
+			text: "#a [[X]] #a\n#a [[X]]\n",
+			want: []located{
+				{"tag", "#a", 1, 1},
+				{"wikilink", "[[X]]", 1, 4},
+				{"tag", "#a", 1, 10},
+				{"tag", "#a", 2, 1},
+				{"wikilink", "[[X]]", 2, 4},
+			},
+		},
+		{
+			name: "link text",
+			text: "[about #a and [[X]]](https://e.com) then #a and [[X]]\n",
+			want: []located{
+				{"url", "https://e.com", 1, 22},
+				{"tag", "#a", 1, 42},
+				{"wikilink", "[[X]]", 1, 49},
+			},
+		},
+		{
+			name: "reference and autolinks",
+			text: "[ref #a][r] #a <https://q.com> #a [short #b] #b\n\n[r]: https://r.com\n[short #b]: https://s.com\n",
+			want: []located{
+				{"tag", "#a", 1, 13},
+				{"url", "https://q.com", 1, 17},
+				{"tag", "#a", 1, 32},
+				{"tag", "#b", 1, 46},
+				{"url", "https://r.com", 3, 6},
+				{"url", "https://s.com", 4, 13},
+			},
+		},
+		{
+			name: "image alt text",
+			text: "![alt #a [[X]]](img.png) #a ![[X]]\n",
+			want: []located{
+				{"tag", "#a", 1, 26},
+				{"wikilink", "[[X]]", 1, 30},
+			},
+		},
+		{
+			name: "inline code and html",
+			text: "`#a [[X]]` #a [[X]] <b>#a</b> <b> #a</b>\n",
+			want: []located{
+				{"tag", "#a", 1, 12},
+				{"wikilink", "[[X]]", 1, 15},
+				{"tag", "#a", 1, 35},
+			},
+		},
+		{
+			name: "tags following inline markup",
+			text: "*foo*#a [[X]]#b `c`#c **d** #d\n#e\n",
+			want: []located{
+				{"wikilink", "[[X]]", 1, 9},
+				{"tag", "#d", 1, 29},
+				{"tag", "#e", 2, 1},
+			},
+		},
+		{
+			name: "tags opening emphasis",
+			text: "**#a** _#b_ ==#c== *x #d*\n",
+			want: []located{
+				{"tag", "#a", 1, 3},
+				{"tag", "#b", 1, 9},
+				{"tag", "#c", 1, 15},
+				{"tag", "#d", 1, 23},
+			},
+		},
+		{
+			name: "table wikilinks with escaped pipes",
+			text: "| a | [[X\\|y]] |\n|---|---|\n| #a | [[X\\|y]] |\n",
+			want: []located{
+				{"wikilink", "[[X\\|y]]", 1, 7},
+				{"tag", "#a", 3, 3},
+				{"wikilink", "[[X\\|y]]", 3, 8},
+			},
+		},
+		{
+			name: "setext headings",
+			text: "Title\n=====\n\nSub #a\n---\n\n# Title\n\n## Sub\n",
+			want: []located{
+				{"heading", "Title", 1, 1},
+				{"heading", "Sub #a", 4, 1},
+				{"tag", "#a", 4, 5},
+				{"heading", "# Title", 7, 1},
+				{"heading", "## Sub", 9, 1},
+			},
+		},
+		{
+			name: "crlf line-endings",
+			text: "# H\r\n\r\n#a and [[X]]\r\n\r\n- [ ] task #a\r\n\r\npara ^p1\r\n",
+			want: []located{
+				{"heading", "# H", 1, 1},
+				{"tag", "#a", 3, 1},
+				{"wikilink", "[[X]]", 3, 8},
+				{"task", "- [ ] task #a", 5, 1},
+				{"tag", "#a", 5, 12},
+				{"block", "para ^p1", 7, 1},
+			},
+		},
+		{
+			name: "frontmatter offsets",
+			text: "---\ntitle: x\ntags: [a]\n---\n# T\n#a [[X]]\n",
+			want: []located{
+				{"heading", "# T", 5, 1},
+				{"tag", "#a", 6, 1},
+				{"wikilink", "[[X]]", 6, 4},
+			},
+		},
+		{
+			name: "unclosed fence",
+			text: "text #a\n\n```!schema\nkey: #b\n",
+			want: []located{
+				{"tag", "#a", 1, 6},
+				{"metadata", "```!schema\nkey: #b", 3, 1},
+			},
+		},
+		{
+			name: "fence holding entities",
+			text: "~~~~\n#a [[X]]\n~~~\n~~~~\n#a [[X]]\n",
+			want: []located{
+				{"tag", "#a", 5, 1},
+				{"wikilink", "[[X]]", 5, 4},
+			},
+		},
+		{
+			name: "repeated tasks",
+			text: "- [ ] same #a\n- [x] same #a\n  - [ ] same #a\n",
+			want: []located{
+				{"task", "- [ ] same #a", 1, 1},
+				{"tag", "#a", 1, 12},
+				{"task", "- [x] same #a", 2, 1},
+				{"tag", "#a", 2, 12},
+				{"task", "  - [ ] same #a", 3, 1},
+				{"tag", "#a", 3, 14},
+			},
+		},
+		{
+			name: "blocks",
+			text: "para #a ^p1\n\n- item ^i1\n\n> quote\n\n^q1\n",
+			want: []located{
+				{"block", "para #a ^p1", 1, 1},
+				{"tag", "#a", 1, 6},
+				{"block", "- item ^i1", 3, 1},
+				{"block", "> quote\n\n^q1", 5, 1},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := extractLocated(t, tc.text)
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("positions in %q:\n got  %v\n want %v", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindHeading(t *testing.T) {
+	cases := []struct {
+		name   string
+		text   string
+		level  int
+		title  string
+		offset int
+		want   string
+		ok     bool
+	}{
+		{"atx", "intro\n## Two\n", 2, "Two", 0, "## Two", true},
+		{"atx without text", "#\n", 1, "", 0, "#", true},
+		{"not a tag", "#tag\n# H\n", 1, "H", 0, "# H", true},
+		{"level must match", "### Three\n## Two\n", 2, "Two", 0, "## Two", true},
+		{"setext level one", "Title\n===\n", 1, "Title", 0, "Title", true},
+		{"setext level two", "Title\n---\n", 2, "Title", 0, "Title", true},
+		{"preferring the title", "# Other\n# Wanted\n", 1, "Wanted", 0, "# Wanted", true},
+		{"falling back to any heading", "# Other\n", 1, "**Bold**", 0, "# Other", true},
+		{"searching from the next line", "# H tail\n# H\n", 1, "H", 3, "# H", true},
+		{"crlf", "# H\r\n", 1, "H", 0, "# H", true},
+		{"missing", "text\n", 1, "H", 0, "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, ok := findHeading(tc.text, tc.level, tc.title, tc.offset)
+
+			if ok != tc.ok || (ok && tc.text[start:end] != tc.want) {
+				t.Errorf("findHeading(%q) = %q, %v; want %q, %v", tc.text, tc.text[start:end], ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestReadSections(t *testing.T) {
+	// a section's ids, level and heading, the text beneath its heading, and the source its span covers
+	type section struct {
+		Id       int
+		ParentId int
+		Level    int
+		Heading  string
+		Text     string
+		Span     string
+	}
+
+	cases := []struct {
+		name string
+		text string
+		want []section
+	}{
+		{
+			name: "text before the first heading is in no section",
+			text: "intro\n# A\na\n",
+			want: []section{
+				{1, 0, 1, "A", "a", "# A\na\n"},
+			},
+		},
+		{
+			name: "siblings end where the next starts",
+			text: "# A\na\n# B\nb\n",
+			want: []section{
+				{1, 0, 1, "A", "a", "# A\na\n"},
+				{2, 0, 1, "B", "b", "# B\nb\n"},
+			},
+		},
+		{
+			name: "children nest within their parent",
+			text: "# A\na\n## A1\na1\n### A1a\nx\n## A2\na2\n# B\n",
+			want: []section{
+				{1, 0, 1, "A", "a\nA1\na1\nA1a\nx\nA2\na2", "# A\na\n## A1\na1\n### A1a\nx\n## A2\na2\n"},
+				{2, 1, 2, "A1", "a1\nA1a\nx", "## A1\na1\n### A1a\nx\n"},
+				{3, 2, 3, "A1a", "x", "### A1a\nx\n"},
+				{4, 1, 2, "A2", "a2", "## A2\na2\n"},
+				{5, 0, 1, "B", "", "# B\n"},
+			},
+		},
+		{
+			name: "a skipped level nests under the closest higher heading",
+			text: "## A\n#### A1\nx\n### A2\n",
+			want: []section{
+				{1, 0, 2, "A", "A1\nx\nA2", "## A\n#### A1\nx\n### A2\n"},
+				{2, 1, 4, "A1", "x", "#### A1\nx\n"},
+				{3, 1, 3, "A2", "", "### A2\n"},
+			},
+		},
+		{
+			name: "a higher heading closes deeper sections",
+			text: "### A\n## B\nb\n",
+			want: []section{
+				{1, 0, 3, "A", "", "### A\n"},
+				{2, 0, 2, "B", "b", "## B\nb\n"},
+			},
+		},
+		{
+			name: "headings in code-blocks and quotes open no section",
+			text: "# A\n```\n# not\n```\n> # quoted\n",
+			want: []section{
+				{1, 0, 1, "A", "# not\nquoted", "# A\n```\n# not\n```\n> # quoted\n"},
+			},
+		},
+	}
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			note := NewNote("note.md")
+			if err := note.ExtractData(tc.text, cfg); err != nil {
+				t.Fatal(err)
+			}
+			if err := note.Walk(cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			got := []section{}
+			for _, read := range note.data.Sections {
+				got = append(got, section{
+					read.Id, read.ParentId, read.Level, read.Heading, read.Text, tc.text[read.Start:read.End],
+				})
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("sections in %q:\n got  %+v\n want %+v", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindCodeBlock(t *testing.T) {
+	cases := []struct {
+		name   string
+		text   string
+		info   string
+		offset int
+		want   string
+		ok     bool
+	}{
+		{"backticks", "a\n```go\nx\n```\nb\n", "go", 0, "```go\nx\n```", true},
+		{"tildes", "~~~go\nx\n~~~\n", "go", 0, "~~~go\nx\n~~~", true},
+		{"info in text first", "use go\n```go\nx\n```\n", "go", 0, "```go\nx\n```", true},
+		{"shorter inner fence", "````md\n```\n````\n", "md", 0, "````md\n```\n````", true},
+		{"longer closing fence", "```\nx\n`````\n", "", 0, "```\nx\n`````", true},
+		{"unclosed", "```go\nx\n\n", "go", 0, "```go\nx", true},
+		{"crlf", "```go\r\nx\r\n```\r\n", "go", 0, "```go\r\nx\r\n```", true},
+		{"searching from an offset", "```go\na\n```\n```go\nb\n```\n", "go", 4, "```go\nb\n```", true},
+		{"missing", "go\n", "go", 0, "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, ok := findCodeBlock(tc.text, tc.info, tc.offset)
+
+			if ok != tc.ok || (ok && tc.text[start:end] != tc.want) {
+				t.Errorf("findCodeBlock(%q) = %q, %v; want %q, %v", tc.text, tc.text[start:end], ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestFindBlock(t *testing.T) {
+	cases := []struct {
+		name      string
+		text      string
+		id        string
+		blockType string
+		offset    int
+		want      string
+		ok        bool
+	}{
+		{"paragraph", "a\n\nline one\nline two ^p\n", "p", BLOCK_PARAGRAPH, 0, "line one\nline two ^p", true},
+		{"paragraph after a heading", "# H\ntext ^p\n", "p", BLOCK_PARAGRAPH, 0, "text ^p", true},
+		{"list item", "- one\n- two\n  more ^i\n", "i", BLOCK_LIST_ITEM, 0, "- two\n  more ^i", true},
+		{"marker after a table", "| a |\n|---|\n\n^t\n", "t", BLOCK_TABLE, 0, "| a |\n|---|\n\n^t", true},
+		{"crlf", "text ^p\r\n", "p", BLOCK_PARAGRAPH, 0, "text ^p", true},
+		{"id within a word", "a^p\n", "p", BLOCK_PARAGRAPH, 0, "", false},
+		{"longer id", "a ^pq\n", "p", BLOCK_PARAGRAPH, 0, "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, ok := findBlock(tc.text, tc.id, tc.blockType, tc.offset)
+
+			if ok != tc.ok || (ok && tc.text[start:end] != tc.want) {
+				t.Errorf("findBlock(%q) = %q, %v; want %q, %v", tc.text, tc.text[start:end], ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestFindLinkEnd(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		image    bool
+		label    string
+		autolink bool
+		want     string
+	}{
+		{"inline", "[a](b) tail", false, "a", false, " tail"},
+		{"nested brackets", "[a [[X]] b](c) tail", false, "a [[X]] b", false, " tail"},
+		{"parentheses in the destination", "[a](b_(c)) tail", false, "a", false, " tail"},
+		{"title", `[a](b "t") tail`, false, "a", false, " tail"},
+		{"reference", "[a][r] tail", false, "a", false, " tail"},
+		{"collapsed reference", "[a][] tail", false, "a", false, " tail"},
+		{"shortcut reference", "[x] [a] tail", false, "a", false, " tail"},
+		{"escaped bracket", `[a\]](b) tail`, false, "a]", false, " tail"},
+		{"image", "[x](y) ![a](b) tail", true, "a", false, " tail"},
+		{"bare autolink", "https://e.com tail", false, "https://e.com", true, " tail"},
+		{"angle autolink", "<https://e.com> tail", false, "https://e.com", true, " tail"},
+		{"autolink as link text", "[https://e.com](https://e.com) tail", false, "https://e.com", true, " tail"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			end, ok := findLinkEnd(tc.text, 0, tc.image, tc.label, tc.autolink)
+
+			if !ok || tc.text[end:] != tc.want {
+				t.Errorf("findLinkEnd(%q) left %q, %v; want %q", tc.text, tc.text[end:], ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestCloseFences(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"```\nx\n```\n", "```\nx\n```\n"},
+		{"```go\nx\n", "```go\nx\n```\n"},
+		{"~~~~\nx\n~~~\n", "~~~~\nx\n~~~\n~~~~\n"},
+		{"``` a`b\n", "``` a`b\n"},
+		{"```", "```\n```\n"},
+	}
+
+	for _, tc := range cases {
+		if got := string(closeFences([]byte(tc.text))); got != tc.want {
+			t.Errorf("closeFences(%q) = %q; want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestHeadingSlug(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"Q: What?", "q-what?"},
+		{"Q what?", "q-what?"},
+		{"  Spaced   out  ", "spaced-out"},
+		{"A #tag | pipe ^ caret [x]", "a-tag-pipe-caret-x"},
+		{"100% done", "100-done"},
+		{"Ünïcode Heading", "ünïcode-heading"},
+	}
+
+	for _, tc := range cases {
+		if got := HeadingSlug(tc.text); got != tc.want {
+			t.Errorf("HeadingSlug(%q) = %q; want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+/*
+ * A billion-laughs document: each of ten levels aliases the level before it
+ * ten times
+ *
+ */
+func aliasBomb() string {
+	lines := []string{"a0: &a0 [x, x, x, x, x, x, x, x, x, x]"}
+	for level := 1; level < 10; level++ {
+		previous := fmt.Sprintf("*a%d", level-1)
+		aliases := strings.TrimSuffix(strings.Repeat(previous+", ", 10), ", ")
+		lines = append(lines, fmt.Sprintf("a%d: &a%d [%s]", level, level, aliases))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestExtractFrontmatter(t *testing.T) {
+	cases := []struct {
+		name        string
+		text        string
+		frontmatter string
+		id          string
+		diagnostic  int
+	}{
+		{
+			name:        "read as YAML 1.2",
+			text:        "---\nanswer: no\non: y\ntags: [x, y]\n---\nbody\n",
+			frontmatter: `{"answer":"no","on":"y","tags":["x","y"]}`,
+		},
+		{
+			name:        "numeric ids",
+			text:        "---\nid: 12345678901\n---\n",
+			frontmatter: `{"id":12345678901}`,
+			id:          "12345678901",
+		},
+		{
+			name:       "invalid yaml",
+			text:       "---\na: 1\nb: c: d\n---\n",
+			diagnostic: 3,
+		},
+		{
+			name:       "an alias containing itself",
+			text:       "---\na: &x [*x]\n---\n",
+			diagnostic: 2,
+		},
+		{
+			name:       "excessive aliasing",
+			text:       "---\n" + aliasBomb() + "---\n",
+			diagnostic: 2,
+		},
+	}
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			note := NewNote("note.md")
+			if err := note.ExtractData(tc.text, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			if note.data.Frontmatter != tc.frontmatter {
+				t.Errorf("frontmatter = %s; want %s", note.data.Frontmatter, tc.frontmatter)
+			}
+			if id := note.FrontmatterId(); id != tc.id {
+				t.Errorf("FrontmatterId() = %q; want %q", id, tc.id)
+			}
+
+			line := 0
+			if len(note.data.Diagnostics) > 0 {
+				line = note.data.Diagnostics[0].Line
+			}
+			if line != tc.diagnostic {
+				t.Errorf("diagnostic line = %d; want %d (%+v)", line, tc.diagnostic, note.data.Diagnostics)
+			}
+		})
+	}
+}
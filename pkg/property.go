@@ -0,0 +1,293 @@
+package diatom
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Obsidian property types, as written in .obsidian/types.json
+const PROPERTY_TYPE_TEXT = "text"
+const PROPERTY_TYPE_MULTITEXT = "multitext"
+const PROPERTY_TYPE_NUMBER = "number"
+const PROPERTY_TYPE_CHECKBOX = "checkbox"
+const PROPERTY_TYPE_DATE = "date"
+const PROPERTY_TYPE_DATETIME = "datetime"
+const PROPERTY_TYPE_TAGS = "tags"
+const PROPERTY_TYPE_ALIASES = "aliases"
+
+// maps are not an Obsidian type, but frontmatter may hold them
+const PROPERTY_TYPE_OBJECT = "object"
+
+// Types Obsidian gives its own properties, unless types.json says otherwise
+var DEFAULT_PROPERTY_TYPES = map[string]string{
+	"tags":       PROPERTY_TYPE_TAGS,
+	"aliases":    PROPERTY_TYPE_ALIASES,
+	"cssclasses": PROPERTY_TYPE_MULTITEXT,
+}
+
+// Property types holding lists
+var LIST_PROPERTY_TYPES = map[string]bool{
+	PROPERTY_TYPE_MULTITEXT: true,
+	PROPERTY_TYPE_TAGS:      true,
+	PROPERTY_TYPE_ALIASES:   true,
+}
+
+/*
+ * Infer the Obsidian type of an undeclared property from its value
+ *
+ */
+func InferPropertyType(key string, value interface{}) string {
+	if propertyType, ok := DEFAULT_PROPERTY_TYPES[key]; ok {
+		return propertyType
+	}
+
+	switch value := value.(type) {
+	case bool:
+		return PROPERTY_TYPE_CHECKBOX
+	case float64:
+		return PROPERTY_TYPE_NUMBER
+	case []interface{}:
+		return PROPERTY_TYPE_MULTITEXT
+	case map[string]interface{}:
+		return PROPERTY_TYPE_OBJECT
+	case string:
+		if inferScalarType(value) == FIELD_TYPE_DATE {
+			if strings.ContainsAny(value, "T ") {
+				return PROPERTY_TYPE_DATETIME
+			}
+			return PROPERTY_TYPE_DATE
+		}
+	}
+
+	return PROPERTY_TYPE_TEXT
+}
+
+/*
+ * Set the typed column for one value. Values not matching their type, such as
+ * text in a number property, are kept as text; text holding a wikilink also
+ * sets the link to its target.
+ *
+ */
+func (property *Property) setValue(valueType string, value interface{}) {
+	switch value := value.(type) {
+	case nil:
+		return
+	case bool:
+		if valueType == PROPERTY_TYPE_CHECKBOX {
+			property.Boolean = &value
+			return
+		}
+	case float64:
+		if valueType == PROPERTY_TYPE_NUMBER {
+			property.Number = &value
+			return
+		}
+	case string:
+		switch valueType {
+		case PROPERTY_TYPE_NUMBER:
+			if number, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				property.Number = &number
+				return
+			}
+		case PROPERTY_TYPE_CHECKBOX:
+			if checked, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+				property.Boolean = &checked
+				return
+			}
+		case PROPERTY_TYPE_DATE, PROPERTY_TYPE_DATETIME:
+			if inferScalarType(value) == FIELD_TYPE_DATE {
+				property.Date = value
+				return
+			}
+		}
+
+		property.Text = value
+		if fieldLinkPattern.MatchString(strings.TrimSpace(value)) {
+			property.Link = ParseWikilink(strings.TrimPrefix(strings.TrimSpace(value), "!")).Target
+		}
+		return
+	}
+
+	// other values, such as a number in a text property, are stored as json
+	bytes, err := json.Marshal(value)
+	if err == nil {
+		property.Text = string(bytes)
+	}
+}
+
+/*
+ * Convert a yaml node to the values json would decode it to. Timestamps keep
+ * their text, and numbers are float64, as Obsidian reads them.
+ *
+ */
+func yamlValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	case yaml.SequenceNode:
+		values := []interface{}{}
+		for _, child := range node.Content {
+			value, err := yamlValue(child)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case yaml.MappingNode:
+		values := map[string]interface{}{}
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key, child := node.Content[idx], node.Content[idx+1]
+
+			value, err := yamlValue(child)
+			if err != nil {
+				return nil, err
+			}
+
+			// merge keys copy the keys of the mapping they reference
+			if key.Tag == "!!merge" {
+				if merged, ok := value.(map[string]interface{}); ok {
+					for mergedKey, mergedValue := range merged {
+						if _, set := values[mergedKey]; !set {
+							values[mergedKey] = mergedValue
+						}
+					}
+				}
+				continue
+			}
+
+			values[key.Value] = value
+		}
+		return values, nil
+	}
+
+	if node.Tag == "!!timestamp" {
+		return node.Value, nil
+	}
+
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	switch number := value.(type) {
+	case int:
+		return float64(number), nil
+	case int64:
+		return float64(number), nil
+	case uint64:
+		return float64(number), nil
+	}
+
+	return value, nil
+}
+
+/*
+ * Decode a note's yaml frontmatter to the values json would decode it to. Values
+ * are read as YAML 1.2, as Obsidian does, so on, no and y are text rather than
+ * booleans.
+ *
+ */
+func DecodeFrontmatter(frontmatter string) (map[string]interface{}, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(frontmatter), &document); err != nil {
+		return nil, err
+	}
+
+	// yaml.v3's decoder refuses alias cycles and excessive aliasing, so check the
+	// document with it before its aliases are expanded below
+	var checked interface{}
+	if err := document.Decode(&checked); err != nil {
+		return nil, err
+	}
+
+	decoded, err := yamlValue(&document)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := decoded.(map[string]interface{})
+	if !ok {
+		if decoded != nil {
+			return nil, fmt.Errorf("frontmatter is not a mapping")
+		}
+		values = map[string]interface{}{}
+	}
+
+	return values, nil
+}
+
+/*
+ * Flatten a note's decoded frontmatter into properties: one per key, or one per
+ * element of a list. Types are read from the declared types, falling back to
+ * types inferred from each value.
+ *
+ */
+func FrontmatterProperties(values map[string]interface{}, types map[string]string) []Property {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	properties := []Property{}
+	for _, key := range keys {
+		value := values[key]
+
+		propertyType, declared := types[key]
+		if !declared {
+			propertyType = InferPropertyType(key, value)
+		}
+
+		// list elements are text in list properties, and have the property's type otherwise
+		elementType := propertyType
+		if LIST_PROPERTY_TYPES[propertyType] {
+			elementType = PROPERTY_TYPE_TEXT
+		}
+
+		// an empty list still has a row, without a value
+		elements, isList := value.([]interface{})
+		if !isList || len(elements) == 0 {
+			if isList {
+				value = nil
+			}
+
+			property := Property{Key: key, Index: -1, Type: propertyType}
+			property.setValue(elementType, value)
+
+			properties = append(properties, property)
+			continue
+		}
+
+		for idx, element := range elements {
+			property := Property{Key: key, Index: idx, Type: propertyType}
+			property.setValue(elementType, element)
+
+			properties = append(properties, property)
+		}
+	}
+
+	return properties
+}
+
+/*
+ * Read typed properties from a note's frontmatter
+ *
+ */
+func (note *ObsidianNote) ReadProperties(types map[string]string) {
+	if note.data == nil || note.data.Frontmatter == "" {
+		return
+	}
+
+	note.data.Properties = FrontmatterProperties(note.frontMatter, types)
+}
@@ -0,0 +1,117 @@
+package diatom
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFrontmatterProperties(t *testing.T) {
+	number := func(value float64) *float64 { return &value }
+	boolean := func(value bool) *bool { return &value }
+
+	cases := []struct {
+		name  string
+		yaml  string
+		types map[string]string
+		want  []Property
+	}{
+		{
+			name: "on is text",
+			yaml: "status: on\n",
+			want: []Property{{Key: "status", Index: -1, Type: PROPERTY_TYPE_TEXT, Text: "on"}},
+		},
+		{
+			name: "no is text",
+			yaml: "answer: no\n",
+			want: []Property{{Key: "answer", Index: -1, Type: PROPERTY_TYPE_TEXT, Text: "no"}},
+		},
+		{
+			name: "y and n in a list are text",
+			yaml: "tags: [y, n]\n",
+			want: []Property{
+				{Key: "tags", Index: 0, Type: PROPERTY_TYPE_TAGS, Text: "y"},
+				{Key: "tags", Index: 1, Type: PROPERTY_TYPE_TAGS, Text: "n"},
+			},
+		},
+		{
+			name: "true is a checkbox",
+			yaml: "done: true\n",
+			want: []Property{{Key: "done", Index: -1, Type: PROPERTY_TYPE_CHECKBOX, Boolean: boolean(true)}},
+		},
+		{
+			name:  "declared checkbox",
+			yaml:  "done: false\n",
+			types: map[string]string{"done": PROPERTY_TYPE_CHECKBOX},
+			want:  []Property{{Key: "done", Index: -1, Type: PROPERTY_TYPE_CHECKBOX, Boolean: boolean(false)}},
+		},
+		{
+			name: "integers are numbers",
+			yaml: "n: 3\n",
+			want: []Property{{Key: "n", Index: -1, Type: PROPERTY_TYPE_NUMBER, Number: number(3)}},
+		},
+		{
+			name: "dates keep their text",
+			yaml: "due: 2024-01-02\n",
+			want: []Property{{Key: "due", Index: -1, Type: PROPERTY_TYPE_DATE, Date: "2024-01-02"}},
+		},
+		{
+			name: "datetimes keep their text",
+			yaml: "at: 2024-01-02T10:00\n",
+			want: []Property{{Key: "at", Index: -1, Type: PROPERTY_TYPE_DATETIME, Date: "2024-01-02T10:00"}},
+		},
+		{
+			name: "wikilinks",
+			yaml: "up: \"[[Home|home]]\"\n",
+			want: []Property{{Key: "up", Index: -1, Type: PROPERTY_TYPE_TEXT, Text: "[[Home|home]]", Link: "Home"}},
+		},
+		{
+			name: "empty values and lists",
+			yaml: "a:\nb: []\n",
+			want: []Property{
+				{Key: "a", Index: -1, Type: PROPERTY_TYPE_TEXT},
+				{Key: "b", Index: -1, Type: PROPERTY_TYPE_MULTITEXT},
+			},
+		},
+		{
+			name: "objects are stored as json",
+			yaml: "place: {city: Dublin, zip: 1}\n",
+			want: []Property{{Key: "place", Index: -1, Type: PROPERTY_TYPE_OBJECT, Text: `{"city":"Dublin","zip":1}`}},
+		},
+		{
+			name: "aliases and merge keys",
+			yaml: "base: &base {a: 1}\nmore:\n  <<: *base\n  b: 2\n",
+			want: []Property{
+				{Key: "base", Index: -1, Type: PROPERTY_TYPE_OBJECT, Text: `{"a":1}`},
+				{Key: "more", Index: -1, Type: PROPERTY_TYPE_OBJECT, Text: `{"a":1,"b":2}`},
+			},
+		},
+		{
+			name: "empty frontmatter",
+			yaml: "",
+			want: []Property{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			values, err := DecodeFrontmatter(tc.yaml)
+			if err != nil {
+				t.Fatalf("DecodeFrontmatter(%q): %v", tc.yaml, err)
+			}
+
+			got := FrontmatterProperties(values, tc.types)
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FrontmatterProperties(%q)\n got: %+v\nwant: %+v", tc.yaml, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeFrontmatterInvalid(t *testing.T) {
+	for _, yaml := range []string{"- a\n- b\n", "a: [b\n", "a: &x [*x]\n", aliasBomb()} {
+		if _, err := DecodeFrontmatter(yaml); err == nil {
+			t.Errorf("DecodeFrontmatter(%q) succeeded, want an error", yaml)
+		}
+	}
+}
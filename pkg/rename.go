@@ -0,0 +1,120 @@
+package diatom
+
+import (
+	"sync"
+)
+
+const RENAME_BY_HASH = "hash"
+const RENAME_BY_FRONTMATTER_ID = "frontmatter-id"
+
+// Notes that vanished from the vault, which newly found notes may have been renamed from.
+// Vanished notes are keyed by their stored vault-relative path.
+type RenameCandidates struct {
+	lock    sync.Mutex
+	byHash  map[string][]string
+	byId    map[string]string
+	claimed map[string]bool
+}
+
+/*
+ * Construct rename candidates from vanished notes' stored hashes, and the
+ * `id` from their frontmatter when present, each keyed by the note's
+ * vault-relative path
+ *
+ */
+func NewRenameCandidates(hashes map[string]string, frontmatterIds map[string]string) *RenameCandidates {
+	candidates := &RenameCandidates{
+		byHash:  map[string][]string{},
+		byId:    map[string]string{},
+		claimed: map[string]bool{},
+	}
+
+	for rel, hash := range hashes {
+		candidates.byHash[hash] = append(candidates.byHash[hash], rel)
+	}
+
+	for rel, id := range frontmatterIds {
+		candidates.byId[id] = rel
+	}
+
+	return candidates
+}
+
+/*
+ * Claim the vanished note a new note was renamed from, returning its stored path
+ * and why it matched. A matching frontmatter id is preferred over a matching
+ * content hash; each vanished note is claimed once.
+ *
+ */
+func (candidates *RenameCandidates) Claim(hash, frontmatterId string) (string, string, bool) {
+	if candidates == nil {
+		return "", "", false
+	}
+
+	candidates.lock.Lock()
+	defer candidates.lock.Unlock()
+
+	if rel, ok := candidates.byId[frontmatterId]; ok && frontmatterId != "" && !candidates.claimed[rel] {
+		candidates.claimed[rel] = true
+		return rel, RENAME_BY_FRONTMATTER_ID, true
+	}
+
+	for _, rel := range candidates.byHash[hash] {
+		if !candidates.claimed[rel] {
+			candidates.claimed[rel] = true
+			return rel, RENAME_BY_HASH, true
+		}
+	}
+
+	return "", "", false
+}
+
+/*
+ * List the paths of vanished notes that no new note was renamed from
+ *
+ */
+func (candidates *RenameCandidates) Unclaimed() []string {
+	candidates.lock.Lock()
+	defer candidates.lock.Unlock()
+
+	unclaimed := []string{}
+	for _, paths := range candidates.byHash {
+		for _, rel := range paths {
+			if !candidates.claimed[rel] {
+				unclaimed = append(unclaimed, rel)
+			}
+		}
+	}
+
+	return unclaimed
+}
+
+/*
+ * Find rename candidates among notes that no longer exist, given their
+ * vault-relative paths
+ *
+ */
+func (conn *ObsidianDB) GetRenameCandidates(vanished []string) (*RenameCandidates, error) {
+	allHashes, err := conn.GetFileHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	frontmatterIds, err := conn.GetFrontmatterIds()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]string{}
+	ids := map[string]string{}
+
+	for _, rel := range vanished {
+		hashes[rel] = allHashes[rel]
+
+		if id, ok := frontmatterIds[rel]; ok {
+			ids[rel] = id
+		}
+	}
+
+	return NewRenameCandidates(hashes, ids), nil
+}
@@ -0,0 +1,121 @@
+package diatom
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestRenameCandidatesClaim(t *testing.T) {
+	// two vanished notes share a hash, and one of them has a frontmatter id
+	hashes := map[string]string{
+		"a.md":     "same",
+		"b.md":     "same",
+		"c.md":     "other",
+		"dir/d.md": "edited",
+	}
+	ids := map[string]string{
+		"dir/d.md": "note-d",
+	}
+
+	type claim struct {
+		rel    string
+		reason string
+		ok     bool
+	}
+
+	cases := []struct {
+		name   string
+		claims [][2]string
+		want   []claim
+	}{
+		{
+			name:   "a frontmatter id is preferred to a hash",
+			claims: [][2]string{{"same", "note-d"}},
+			want:   []claim{{"dir/d.md", RENAME_BY_FRONTMATTER_ID, true}},
+		},
+		{
+			name:   "an edited note is followed by its frontmatter id",
+			claims: [][2]string{{"changed", "note-d"}},
+			want:   []claim{{"dir/d.md", RENAME_BY_FRONTMATTER_ID, true}},
+		},
+		{
+			name:   "a claimed id falls back to the hash",
+			claims: [][2]string{{"other", "note-d"}, {"other", "note-d"}},
+			want: []claim{
+				{"dir/d.md", RENAME_BY_FRONTMATTER_ID, true},
+				{"c.md", RENAME_BY_HASH, true},
+			},
+		},
+		{
+			name:   "each note with a shared hash is claimed once",
+			claims: [][2]string{{"same", ""}, {"same", ""}, {"same", ""}},
+			want: []claim{
+				{"a.md", RENAME_BY_HASH, true},
+				{"b.md", RENAME_BY_HASH, true},
+				{"", "", false},
+			},
+		},
+		{
+			name:   "unknown hashes and ids claim nothing",
+			claims: [][2]string{{"unknown", "unknown"}, {"", ""}},
+			want:   []claim{{"", "", false}, {"", "", false}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			candidates := NewRenameCandidates(hashes, ids)
+			claimed := map[string]bool{}
+
+			for idx, args := range tc.claims {
+				rel, reason, ok := candidates.Claim(args[0], args[1])
+				want := tc.want[idx]
+
+				// notes sharing a hash may be claimed in either order
+				if want.reason == RENAME_BY_HASH && hashes[want.rel] == "same" && hashes[rel] == "same" {
+					want.rel = rel
+				}
+
+				if rel != want.rel || reason != want.reason || ok != want.ok {
+					t.Errorf("Claim(%q, %q) = %q, %q, %v; want %q, %q, %v",
+						args[0], args[1], rel, reason, ok, want.rel, want.reason, want.ok)
+				}
+				if ok && claimed[rel] {
+					t.Errorf("Claim(%q, %q) claimed %q twice", args[0], args[1], rel)
+				}
+				claimed[rel] = ok
+			}
+
+			unclaimed := candidates.Unclaimed()
+			sort.Strings(unclaimed)
+
+			for _, rel := range unclaimed {
+				if claimed[rel] {
+					t.Errorf("Unclaimed() = %v; includes claimed %q", unclaimed, rel)
+				}
+			}
+			if len(unclaimed)+len(claimedPaths(claimed)) != len(hashes) {
+				t.Errorf("Unclaimed() = %v; want every note not claimed", unclaimed)
+			}
+		})
+	}
+}
+
+func TestRenameCandidatesNil(t *testing.T) {
+	var candidates *RenameCandidates
+
+	if _, _, ok := candidates.Claim("hash", "id"); ok {
+		t.Errorf("Claim on nil candidates succeeded")
+	}
+}
+
+func claimedPaths(claimed map[string]bool) []string {
+	paths := []string{}
+	for rel, ok := range claimed {
+		if ok {
+			paths = append(paths, rel)
+		}
+	}
+
+	return paths
+}
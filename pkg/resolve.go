@@ -0,0 +1,274 @@
+package diatom
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A note or attachment that wikilinks can resolve to
+type linkCandidate struct {
+	id  string
+	rel string
+}
+
+// Lookup tables for notes or attachments, by normalised path and by basename
+type linkTable struct {
+	byPath map[string]string
+	byName map[string][]linkCandidate
+}
+
+// What a wikilink resolves to: a note's file id, an attachment's path, or neither
+type Resolution struct {
+	FileId     string
+	Attachment string
+}
+
+// Index of vault notes and attachments, used to resolve wikilink targets
+type LinkIndex struct {
+	paths       map[string]string
+	notes       linkTable
+	attachments linkTable
+}
+
+/*
+ * Normalise a vault-relative path or link target for lookup. Obsidian
+ * matches case-insensitively, and the .md extension is optional.
+ *
+ */
+func linkKey(target string) string {
+	key := strings.ToLower(filepath.ToSlash(target))
+	return strings.TrimSuffix(key, ".md")
+}
+
+/*
+ * Normalise an attachment path or link target for lookup; attachment
+ * links always name their extension
+ *
+ */
+func attachmentKey(target string) string {
+	return strings.ToLower(filepath.ToSlash(target))
+}
+
+func newLinkTable() linkTable {
+	return linkTable{byPath: map[string]string{}, byName: map[string][]linkCandidate{}}
+}
+
+func (table *linkTable) add(id, key string) {
+	table.byPath[key] = id
+	table.byName[path.Base(key)] = append(table.byName[path.Base(key)], linkCandidate{id, key})
+}
+
+/*
+ * Construct an index over the files stored for a vault, from their
+ * vault-relative paths keyed by file id, and the vault's attachments
+ *
+ */
+func NewLinkIndex(paths map[string]string, attachments []string) *LinkIndex {
+	index := &LinkIndex{
+		paths:       paths,
+		notes:       newLinkTable(),
+		attachments: newLinkTable(),
+	}
+
+	for fileId, rel := range paths {
+		index.notes.add(fileId, linkKey(rel))
+	}
+
+	for _, rel := range attachments {
+		index.attachments.add(rel, attachmentKey(rel))
+	}
+
+	return index
+}
+
+/*
+ * Resolve a wikilink target to a file id, following Obsidian's rules:
+ *
+ * - an empty target links to the source note itself
+ * - relative targets (./, ../) are resolved against the source note's folder
+ * - a target matching a vault-relative path exactly wins
+ * - otherwise, any note whose path ends with the target matches; a note in the
+ *   source's folder is preferred, then the shortest path
+ *
+ */
+func (index *LinkIndex) Resolve(target, sourceId string) (string, bool) {
+	if target == "" {
+		return sourceId, true
+	}
+
+	return index.notes.lookup(linkKey(target), index.sourceDir(sourceId))
+}
+
+/*
+ * Resolve a wikilink target to an attachment's path, following the
+ * same rules as notes
+ *
+ */
+func (index *LinkIndex) ResolveAttachment(target, sourceId string) (string, bool) {
+	if target == "" {
+		return "", false
+	}
+
+	return index.attachments.lookup(attachmentKey(target), index.sourceDir(sourceId))
+}
+
+/*
+ * Resolve a wikilink target to a note, or failing that an attachment
+ *
+ */
+func (index *LinkIndex) ResolveLink(target, sourceId string) Resolution {
+	if fileId, ok := index.Resolve(target, sourceId); ok {
+		return Resolution{FileId: fileId}
+	}
+
+	if rel, ok := index.ResolveAttachment(target, sourceId); ok {
+		return Resolution{Attachment: rel}
+	}
+
+	return Resolution{}
+}
+
+/*
+ * Does a wikilink resolve to anything?
+ *
+ */
+func (resolution Resolution) Resolved() bool {
+	return resolution.FileId != "" || resolution.Attachment != ""
+}
+
+func (index *LinkIndex) sourceDir(sourceId string) string {
+	if rel, ok := index.paths[sourceId]; ok {
+		return path.Dir(linkKey(rel))
+	}
+
+	return "."
+}
+
+func (table *linkTable) lookup(key, sourceDir string) (string, bool) {
+	if strings.HasPrefix(key, "./") || strings.HasPrefix(key, "../") {
+		id, ok := table.byPath[path.Join(sourceDir, key)]
+		return id, ok
+	}
+
+	key = strings.TrimPrefix(key, "/")
+
+	if id, ok := table.byPath[key]; ok {
+		return id, true
+	}
+
+	matches := []linkCandidate{}
+	for _, candidate := range table.byName[path.Base(key)] {
+		if candidate.rel == key || strings.HasSuffix(candidate.rel, "/"+key) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	sort.Slice(matches, func(idx, jdx int) bool {
+		left, right := matches[idx], matches[jdx]
+
+		leftLocal := path.Dir(left.rel) == sourceDir
+		rightLocal := path.Dir(right.rel) == sourceDir
+		if leftLocal != rightLocal {
+			return leftLocal
+		}
+
+		if len(left.rel) != len(right.rel) {
+			return len(left.rel) < len(right.rel)
+		}
+
+		return left.rel < right.rel
+	})
+
+	return matches[0].id, true
+}
+
+/*
+ * Re-resolve only the wikilinks that notes or attachments changing could affect:
+ * links from the changed notes, links resolved to them, and links whose target
+ * names one of the changed paths, old or new. Returns the files whose in-degree or
+ * out-degree may have changed.
+ *
+ */
+func ResolveNeighbourhood(conn *ObsidianDB, changed []string, changedPaths []string) ([]string, error) {
+	paths, err := conn.GetFilePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := conn.GetAttachmentPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	index := NewLinkIndex(paths, attachments)
+
+	changedIds := map[string]bool{}
+	for _, fileId := range changed {
+		changedIds[fileId] = true
+	}
+
+	changedNames := map[string]bool{}
+	for _, rel := range changedPaths {
+		changedNames[path.Base(linkKey(rel))] = true
+	}
+
+	rows, err := conn.GetWikilinkResolutions()
+	if err != nil {
+		return nil, err
+	}
+
+	affected := map[string]bool{}
+	resolutions := map[int64]Resolution{}
+
+	for rows.Next() {
+		var rowid int64
+		var target, fileId string
+		var previous Resolution
+
+		if err := rows.Scan(&rowid, &target, &fileId, &previous.FileId, &previous.Attachment); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		named := target != "" && changedNames[path.Base(linkKey(target))]
+		if !changedIds[fileId] && !changedIds[previous.FileId] && !named {
+			continue
+		}
+
+		resolved := index.ResolveLink(target, fileId)
+		if resolved == previous && !changedIds[fileId] {
+			continue
+		}
+
+		resolutions[rowid] = resolved
+		affected[previous.FileId] = true
+		affected[resolved.FileId] = true
+	}
+
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := conn.UpdateWikilinkResolutions(resolutions); err != nil {
+		return nil, err
+	}
+
+	for _, fileId := range changed {
+		affected[fileId] = true
+	}
+	delete(affected, "")
+
+	neighbourhood := []string{}
+	for fileId := range affected {
+		neighbourhood = append(neighbourhood, fileId)
+	}
+	sort.Strings(neighbourhood)
+
+	return neighbourhood, nil
+}
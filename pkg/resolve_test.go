@@ -0,0 +1,88 @@
+package diatom
+
+import "testing"
+
+// A vault with notes of the same name in several folders, keyed by file id
+var testLinkPaths = map[string]string{
+	"root":       "Note.md",
+	"a-note":     "A/Note.md",
+	"deep":       "B/Deep/Note.md",
+	"a-other":    "A/Other.md",
+	"b-other":    "B/Other.md",
+	"c-other":    "C/Other.md",
+	"deep-other": "B/Deep/Other.md",
+	"mixed":      "Folder/MixedCase.md",
+	"dotted":     "x/v1.2 notes.md",
+}
+
+var testLinkAttachments = []string{"img/pic.png", "A/pic.png", "B/Deep/scan.pdf"}
+
+func TestResolve(t *testing.T) {
+	index := NewLinkIndex(testLinkPaths, testLinkAttachments)
+
+	cases := []struct {
+		name   string
+		target string
+		source string
+		want   string
+		ok     bool
+	}{
+		{"empty target links to the source", "", "deep", "deep", true},
+		{"exact path", "A/Note", "root", "a-note", true},
+		{"exact path with extension", "A/Note.md", "root", "a-note", true},
+		{"leading slash", "/A/Note", "root", "a-note", true},
+		{"case-insensitive", "a/NOTE", "root", "a-note", true},
+		{"exact path over the source's folder", "Note", "a-other", "root", true},
+		{"name in the source's folder", "Other", "b-other", "b-other", true},
+		{"longer path in the source's folder", "Other", "deep", "deep-other", true},
+		{"shortest path otherwise", "Deep/Note", "a-other", "deep", true},
+		{"path suffix", "Deep/Note", "root", "deep", true},
+		{"suffix must be whole folders", "eep/Note", "root", "", false},
+		{"ties broken by path", "Other", "root", "a-other", true},
+		{"source's folder over shorter paths", "Other", "c-other", "c-other", true},
+		{"relative to the source", "./Other", "a-note", "a-other", true},
+		{"parent of the source", "../B/Other", "a-note", "b-other", true},
+		{"relative targets are not searched", "./Note", "b-other", "", false},
+		{"name ending in a dotted word", "v1.2 notes", "root", "dotted", true},
+		{"partial name", "ixedCase", "root", "", false},
+		{"missing", "Missing", "root", "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := index.Resolve(tc.target, tc.source)
+
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("Resolve(%q, %q) = %q, %v; want %q, %v", tc.target, tc.source, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestResolveLink(t *testing.T) {
+	index := NewLinkIndex(testLinkPaths, testLinkAttachments)
+
+	cases := []struct {
+		name   string
+		target string
+		source string
+		want   Resolution
+	}{
+		{"note", "Note", "root", Resolution{FileId: "root"}},
+		{"attachment in the source's folder", "pic.png", "a-other", Resolution{Attachment: "A/pic.png"}},
+		{"shortest attachment path", "pic.png", "b-other", Resolution{Attachment: "A/pic.png"}},
+		{"attachment path", "img/pic.png", "a-other", Resolution{Attachment: "img/pic.png"}},
+		{"attachment case-insensitive", "Deep/SCAN.pdf", "root", Resolution{Attachment: "B/Deep/scan.pdf"}},
+		{"attachments need their extension", "pic", "root", Resolution{}},
+		{"empty target is not an attachment", "", "missing", Resolution{FileId: "missing"}},
+		{"missing", "nothing.png", "root", Resolution{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := index.ResolveLink(tc.target, tc.source); got != tc.want {
+				t.Errorf("ResolveLink(%q, %q) = %+v; want %+v", tc.target, tc.source, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package diatom
+
+import (
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// A single invocation of diatom against a vault
+type Run struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Vault      string
+	Version    string
+	Error      error
+	Stats      *Stats
+}
+
+/*
+ * The version of this diatom binary: the module version when installed with
+ * `go install`, otherwise the VCS revision it was built from
+ *
+ */
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+	if version != "" && version != "(devel)" {
+		return version
+	}
+
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+
+	if revision == "" {
+		return "(devel)"
+	}
+	if modified {
+		return revision + "-dirty"
+	}
+	return revision
+}
+
+/*
+ * Start recording a run against a vault
+ *
+ */
+func NewRun(dpath string, stats *Stats) *Run {
+	if abs, err := filepath.Abs(dpath); err == nil {
+		dpath = abs
+	}
+
+	return &Run{
+		StartedAt: time.Now(),
+		Vault:     dpath,
+		Version:   Version(),
+		Stats:     stats,
+	}
+}
+
+/*
+ * Save a finished run, with its counters, phase timings and file changes,
+ * in one transaction
+ *
+ */
+func (conn *ObsidianDB) InsertRun(run *Run) error {
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var runErr interface{}
+	if run.Error != nil {
+		runErr = run.Error.Error()
+	}
+
+	res, err := tx.Exec(`
+	insert into run (started_at, finished_at, vault, version, error) values (?, ?, ?, ?, ?)
+	`, run.StartedAt.UTC().Format(time.RFC3339Nano), run.FinishedAt.UTC().Format(time.RFC3339Nano), run.Vault, run.Version, runErr)
+	if err != nil {
+		return err
+	}
+
+	runId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stats := run.Stats
+	stats.Lock.Lock()
+	defer stats.Lock.Unlock()
+
+	for name, value := range stats.Data {
+		if _, err := tx.Exec(`insert into run_stat (run_id, name, value) values (?, ?, ?)`, runId, name, value); err != nil {
+			return err
+		}
+	}
+
+	for phase, duration := range stats.Phases {
+		_, err := tx.Exec(`insert into run_phase (run_id, phase, duration_ms) values (?, ?, ?)`, runId, phase, duration.Milliseconds())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, file := range stats.Files {
+		if _, err := tx.Exec(`insert into run_file (run_id, path, change) values (?, ?, ?)`, runId, file.Path, file.Change); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
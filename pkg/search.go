@@ -0,0 +1,152 @@
+package diatom
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/mattn/go-sqlite3"
+)
+
+var wikilinkText = regexp.MustCompile(`!?\[\[([^\]|]+)(?:\|([^\]]*))?\]\]`)
+
+// A note matching a full-text search
+type SearchResult struct {
+	File    string  `json:"file"`
+	Title   string  `json:"title"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+/*
+ * Extract plain text from a parsed markdown document, dropping
+ * markup and html. Blocks are separated by newlines.
+ *
+ */
+func PlainText(doc ast.Node) string {
+	var text strings.Builder
+
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch node := node.(type) {
+		case *ast.Text:
+			text.Write(node.Literal)
+		case *ast.Code:
+			text.Write(node.Literal)
+		case *ast.CodeBlock:
+			text.Write(node.Literal)
+			text.WriteString("\n")
+		case *ast.Softbreak, *ast.Hardbreak:
+			text.WriteString("\n")
+		case *ast.Paragraph, *ast.Heading, *ast.ListItem, *ast.TableCell:
+			if !entering {
+				text.WriteString("\n")
+			}
+		}
+
+		return ast.GoToNext
+	})
+
+	// show wikilinks as their alias, or their target
+	plain := wikilinkText.ReplaceAllStringFunc(text.String(), func(link string) string {
+		match := wikilinkText.FindStringSubmatch(link)
+		if match[2] != "" {
+			return match[2]
+		}
+		return match[1]
+	})
+
+	return strings.TrimSpace(plain)
+}
+
+/*
+ * Search note text, and write BM25-ranked results with highlighted
+ * snippets. Returns the number of results.
+ *
+ */
+func Search(args *DiatomArgs, query string, limit int, format string, out io.Writer) (int, error) {
+	open, close := "", ""
+
+	switch format {
+	case FORMAT_TEXT:
+		// matches are shown in bold on a terminal, and left plain in pipes and files
+		if isTerminal(out) {
+			open, close = "\x1b[1m", "\x1b[0m"
+		}
+	case FORMAT_JSON:
+		open, close = "<mark>", "</mark>"
+	default:
+		return 0, fmt.Errorf("unknown search format %v (expected %v or %v)", format, FORMAT_TEXT, FORMAT_JSON)
+	}
+
+	conn, err := NewDB(args.Config.DBPath)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	pending, err := conn.PendingMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(pending) > 0 {
+		return 0, fmt.Errorf("database schema is out of date; index a vault or run diatom migrate first")
+	}
+
+	fullText, err := conn.HasFullText()
+	if err != nil {
+		return 0, err
+	}
+
+	if !fullText {
+		if fts5, err := conn.HasFTS5(); err != nil || !fts5 {
+			return 0, fmt.Errorf("full-text search needs SQLite with FTS5; build diatom with -tags sqlite_fts5")
+		}
+		return 0, fmt.Errorf("the database has no full-text index yet; index a vault or run diatom migrate first")
+	}
+
+	// FTS5 reports a query it cannot parse as a generic SQL error
+	results, err := conn.SearchNotes(query, open, close, limit)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrError {
+		return 0, &QueryError{query, err}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if format == FORMAT_JSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return len(results), enc.Encode(results)
+	}
+
+	for _, result := range results {
+		snippet := strings.ReplaceAll(result.Snippet, "\n", " ")
+
+		if _, err := fmt.Fprintf(out, "%v\n  %v\n\n", result.File, snippet); err != nil {
+			return len(results), err
+		}
+	}
+
+	return len(results), nil
+}
+
+/*
+ * Is output written to a terminal, rather than a pipe or file?
+ *
+ */
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
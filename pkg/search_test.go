@@ -0,0 +1,49 @@
+package diatom
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSearchInvalidQuery(t *testing.T) {
+	dir := writeVault(t, map[string]string{"a.md": "# A\nfoo bar\n"})
+	dbpath := filepath.Join(t.TempDir(), "diatom.sqlite")
+	indexVault(t, dir, dbpath, EXTRACTORS)
+
+	conn, err := NewDB(dbpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullText, err := conn.HasFullText()
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fullText {
+		t.Skip("full-text search needs a build with -tags sqlite_fts5")
+	}
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DBPath = dbpath
+	args := &DiatomArgs{Dir: dir, Config: cfg}
+
+	if count, err := Search(args, "foo", 10, FORMAT_JSON, io.Discard); err != nil || count != 1 {
+		t.Errorf("Search(foo) = %v, %v; want one result", count, err)
+	}
+
+	_, err = Search(args, `foo"`, 10, FORMAT_JSON, io.Discard)
+
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || !strings.Contains(err.Error(), `"foo\""`) {
+		t.Fatalf("Search(foo\") error = %v; want a QueryError naming the query", err)
+	}
+	if code := ExitCode(err); code != EXIT_USAGE {
+		t.Errorf("ExitCode() = %v; want %v", code, EXIT_USAGE)
+	}
+}
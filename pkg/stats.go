@@ -2,17 +2,39 @@ package diatom
 
 import (
 	"sync"
+	"time"
 )
 
+const FILE_ADDED = "added"
+const FILE_CHANGED = "changed"
+const FILE_DELETED = "deleted"
+
+const PHASE_REMOVE = "remove"
+const PHASE_EXTRACT = "extract"
+const PHASE_GRAPH = "graph"
+const PHASE_ATTACHMENTS = "attachments"
+
+// A note added, changed or deleted during a run
+type FileChange struct {
+	Path   string
+	Change string
+}
+
 type Stats struct {
-	Data map[string]int
-	Lock sync.Locker
+	Data     map[string]int
+	Phases   map[string]time.Duration
+	Files    []FileChange
+	Failures []*NoteError
+	Lock     sync.Locker
 }
 
 func NewStats() *Stats {
 	return &Stats{
-		Data: map[string]int{},
-		Lock: &sync.Mutex{},
+		Data:     map[string]int{},
+		Phases:   map[string]time.Duration{},
+		Files:    []FileChange{},
+		Failures: []*NoteError{},
+		Lock:     &sync.Mutex{},
 	}
 }
 
@@ -23,3 +45,42 @@ func (stat *Stats) Add(key string) {
 
 	stat.Lock.Unlock()
 }
+
+// Record time spent in a phase since it started
+func (stat *Stats) Time(phase string, start time.Time) {
+	stat.Lock.Lock()
+
+	stat.Phases[phase] += time.Since(start)
+
+	stat.Lock.Unlock()
+}
+
+// Record a note added, changed or deleted
+func (stat *Stats) AddFile(fpath string, change string) {
+	stat.Lock.Lock()
+
+	stat.Files = append(stat.Files, FileChange{fpath, change})
+
+	stat.Lock.Unlock()
+}
+
+// Record a note that failed to index
+func (stat *Stats) Fail(failure *NoteError) {
+	stat.Lock.Lock()
+
+	stat.Failures = append(stat.Failures, failure)
+
+	stat.Lock.Unlock()
+}
+
+// The per-note failures recorded, as an error; nil when every note succeeded
+func (stat *Stats) Err() error {
+	stat.Lock.Lock()
+	defer stat.Lock.Unlock()
+
+	if len(stat.Failures) == 0 {
+		return nil
+	}
+
+	return &RunErrors{append([]*NoteError{}, stat.Failures...)}
+}
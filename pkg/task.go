@@ -0,0 +1,304 @@
+package diatom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const TASK_PRIORITY_HIGHEST = "highest"
+const TASK_PRIORITY_HIGH = "high"
+const TASK_PRIORITY_MEDIUM = "medium"
+const TASK_PRIORITY_NORMAL = "normal"
+const TASK_PRIORITY_LOW = "low"
+const TASK_PRIORITY_LOWEST = "lowest"
+
+// Tasks plugin priority signifiers
+var TASK_PRIORITIES = map[string]string{
+	"🔺": TASK_PRIORITY_HIGHEST,
+	"⏫": TASK_PRIORITY_HIGH,
+	"🔼": TASK_PRIORITY_MEDIUM,
+	"🔽": TASK_PRIORITY_LOW,
+	"⏬": TASK_PRIORITY_LOWEST,
+}
+
+// Status names accepted by `diatom tasks --status`, with the status characters they match
+var TASK_STATUSES = map[string][]string{
+	"todo":        {" "},
+	"in-progress": {"/"},
+	"done":        {"x", "X"},
+	"cancelled":   {"-"},
+}
+
+const TASK_DATE_DUE = "due"
+const TASK_DATE_SCHEDULED = "scheduled"
+const TASK_DATE_START = "start"
+const TASK_DATE_CREATED = "created"
+const TASK_DATE_DONE = "done"
+const TASK_DATE_CANCELLED = "cancelled"
+
+var TASK_DATES = []string{
+	TASK_DATE_DUE,
+	TASK_DATE_SCHEDULED,
+	TASK_DATE_START,
+	TASK_DATE_CREATED,
+	TASK_DATE_DONE,
+	TASK_DATE_CANCELLED,
+}
+
+// A list item holding a checkbox, such as `- [ ] todo` or `> 1. [x] done`
+var taskLinePattern = regexp.MustCompile(`(?m)^[ \t]*(?:>[ \t]*)*(?:[-*+]|\d+[.)])[ \t]+\[(.)\](?:[ \t]+(.*?))?[ \t]*\r?$`)
+
+// The checkbox starting a list item's text
+var taskPrefixPattern = regexp.MustCompile(`^\[(.)\](?:\s|$)`)
+
+// Tasks plugin metadata; like the plugin, it is read from the end of a task's text
+var (
+	taskPriorityPattern   = regexp.MustCompile(`[ \t]*([🔺⏫🔼🔽⏬])\x{FE0F}?$`)
+	taskRecurrencePattern = regexp.MustCompile(`[ \t]*🔁\x{FE0F}? ?([a-zA-Z0-9, !]+)$`)
+	taskTrailingTag       = regexp.MustCompile(`(?:^|\s)#[^ !@#$%^&*(),.?":{}|<>]+$`)
+	taskDatePatterns      = map[string]*regexp.Regexp{
+		TASK_DATE_DUE:       regexp.MustCompile(`[ \t]*[📅📆🗓]\x{FE0F}? *(\d{4}-\d{2}-\d{2})$`),
+		TASK_DATE_SCHEDULED: regexp.MustCompile(`[ \t]*[⏳⌛]\x{FE0F}? *(\d{4}-\d{2}-\d{2})$`),
+		TASK_DATE_START:     regexp.MustCompile(`[ \t]*🛫\x{FE0F}? *(\d{4}-\d{2}-\d{2})$`),
+		TASK_DATE_CREATED:   regexp.MustCompile(`[ \t]*➕\x{FE0F}? *(\d{4}-\d{2}-\d{2})$`),
+		TASK_DATE_DONE:      regexp.MustCompile(`[ \t]*✅\x{FE0F}? *(\d{4}-\d{2}-\d{2})$`),
+		TASK_DATE_CANCELLED: regexp.MustCompile(`[ \t]*❌\x{FE0F}? *(\d{4}-\d{2}-\d{2})$`),
+	}
+)
+
+/*
+ * Read a task from its status character and the text after its checkbox. Metadata
+ * is removed from the end of the text in any order, as the Tasks plugin does; tags
+ * between metadata are kept, and moved to the end of the text. A trailing ^block-id
+ * is removed too.
+ *
+ */
+func ParseTask(status, text string) Task {
+	task := Task{Status: status, Priority: TASK_PRIORITY_NORMAL}
+
+	if id, ok := FindBlockId(text); ok {
+		text = strings.TrimSuffix(strings.TrimSpace(text), "^"+id)
+	}
+	text = strings.TrimSpace(text)
+
+	tags := []string{}
+
+	// each pass removes one piece of metadata, or tag, from the end of the text
+	for matched := true; matched; {
+		matched = false
+
+		if match := taskPriorityPattern.FindStringSubmatch(text); match != nil {
+			task.Priority = TASK_PRIORITIES[match[1]]
+			text, matched = strings.TrimSuffix(text, match[0]), true
+		}
+
+		if match := taskRecurrencePattern.FindStringSubmatch(text); match != nil {
+			task.Recurrence = strings.TrimSpace(match[1])
+			text, matched = strings.TrimSuffix(text, match[0]), true
+		}
+
+		for _, field := range TASK_DATES {
+			if match := taskDatePatterns[field].FindStringSubmatch(text); match != nil {
+				task.SetDate(field, match[1])
+				text, matched = strings.TrimSuffix(text, match[0]), true
+			}
+		}
+
+		if match := taskTrailingTag.FindString(text); match != "" && !matched {
+			tags = append([]string{strings.TrimSpace(match)}, tags...)
+			text, matched = strings.TrimSuffix(text, match), true
+		}
+
+		text = strings.TrimSpace(text)
+	}
+
+	task.Text = strings.TrimSpace(strings.Join(append([]string{text}, tags...), " "))
+	return task
+}
+
+/*
+ * Set one of a task's dates by name
+ *
+ */
+func (task *Task) SetDate(field, date string) {
+	switch field {
+	case TASK_DATE_DUE:
+		task.DueDate = date
+	case TASK_DATE_SCHEDULED:
+		task.ScheduledDate = date
+	case TASK_DATE_START:
+		task.StartDate = date
+	case TASK_DATE_CREATED:
+		task.CreatedDate = date
+	case TASK_DATE_DONE:
+		task.DoneDate = date
+	case TASK_DATE_CANCELLED:
+		task.CancelledDate = date
+	}
+}
+
+/*
+ * The status characters matching a `--status` value: a status name, such
+ * as `todo`, or a single status character
+ *
+ */
+func TaskStatus(value string) ([]string, error) {
+	if chars, ok := TASK_STATUSES[value]; ok {
+		return chars, nil
+	}
+
+	if len([]rune(value)) == 1 {
+		return []string{value}, nil
+	}
+
+	return nil, fmt.Errorf("unknown task status %v (expected todo, in-progress, done, cancelled or a status character)", value)
+}
+
+// Filters for `diatom tasks`. Repeated filters match any of their values; dates are inclusive.
+type TaskFilter struct {
+	Statuses []string
+	Tags     []string
+	Paths    []string
+	// the date compared against From and To
+	Date string
+	From string
+	To   string
+}
+
+/*
+ * Check a filter's dates, and the date they are compared against
+ *
+ */
+func (filter TaskFilter) Validate() error {
+	known := false
+	for _, date := range TASK_DATES {
+		known = known || filter.Date == date
+	}
+
+	if !known {
+		return fmt.Errorf("unknown task date %v (expected one of %v)", filter.Date, strings.Join(TASK_DATES, ", "))
+	}
+
+	for _, date := range []string{filter.From, filter.To} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return fmt.Errorf("task dates must be YYYY-MM-DD, such as 2024-01-31: %v", date)
+		}
+	}
+
+	return nil
+}
+
+// A task found by `diatom tasks`
+type TaskResult struct {
+	File       string   `json:"file"`
+	Line       int      `json:"line"`
+	Status     string   `json:"status"`
+	Text       string   `json:"text"`
+	Priority   string   `json:"priority"`
+	Recurrence string   `json:"recurrence,omitempty"`
+	Created    string   `json:"created,omitempty"`
+	Start      string   `json:"start,omitempty"`
+	Scheduled  string   `json:"scheduled,omitempty"`
+	Due        string   `json:"due,omitempty"`
+	Cancelled  string   `json:"cancelled,omitempty"`
+	Done       string   `json:"done,omitempty"`
+	Tags       []string `json:"tags"`
+}
+
+/*
+ * Format a task as the Tasks plugin writes it, with its
+ * metadata after its text
+ *
+ */
+func (result TaskResult) String() string {
+	parts := []string{"[" + result.Status + "]", result.Text}
+
+	for emoji, priority := range TASK_PRIORITIES {
+		if priority == result.Priority {
+			parts = append(parts, emoji)
+		}
+	}
+
+	if result.Recurrence != "" {
+		parts = append(parts, "🔁 "+result.Recurrence)
+	}
+
+	for _, date := range []struct{ emoji, value string }{
+		{"➕", result.Created},
+		{"🛫", result.Start},
+		{"⏳", result.Scheduled},
+		{"📅", result.Due},
+		{"❌", result.Cancelled},
+		{"✅", result.Done},
+	} {
+		if date.value != "" {
+			parts = append(parts, date.emoji+" "+date.value)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+/*
+ * List the tasks in indexed notes matching a filter, ordered by
+ * note and line. Returns the number of tasks.
+ *
+ */
+func Tasks(args *DiatomArgs, filter TaskFilter, format string, out io.Writer) (int, error) {
+	if format != FORMAT_TEXT && format != FORMAT_JSON {
+		return 0, fmt.Errorf("unknown tasks format %v (expected %v or %v)", format, FORMAT_TEXT, FORMAT_JSON)
+	}
+
+	if err := filter.Validate(); err != nil {
+		return 0, err
+	}
+
+	conn, err := NewDB(args.Config.DBPath)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	pending, err := conn.PendingMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(pending) > 0 {
+		return 0, fmt.Errorf("database schema is out of date; index a vault or run diatom migrate first")
+	}
+
+	results, err := conn.GetTasks(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	// paths are matched with the same globs as --include and --exclude
+	if len(filter.Paths) > 0 {
+		matching := []TaskResult{}
+		for _, result := range results {
+			if matchAny(filter.Paths, result.File) {
+				matching = append(matching, result)
+			}
+		}
+		results = matching
+	}
+
+	if format == FORMAT_JSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return len(results), enc.Encode(results)
+	}
+
+	for _, result := range results {
+		if _, err := fmt.Fprintf(out, "%v:%v: %v\n", result.File, result.Line, result); err != nil {
+			return len(results), err
+		}
+	}
+
+	return len(results), nil
+}
@@ -0,0 +1,116 @@
+package diatom
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTask(t *testing.T) {
+	cases := []struct {
+		name   string
+		status string
+		text   string
+		want   Task
+	}{
+		{
+			name:   "plain",
+			status: " ",
+			text:   "buy milk",
+			want:   Task{Status: " ", Text: "buy milk", Priority: TASK_PRIORITY_NORMAL},
+		},
+		{
+			name:   "every date",
+			status: "x",
+			text:   "ship it ➕ 2024-01-01 🛫 2024-01-02 ⏳ 2024-01-03 📅 2024-01-04 ✅ 2024-01-05",
+			want: Task{
+				Status: "x", Text: "ship it", Priority: TASK_PRIORITY_NORMAL,
+				CreatedDate: "2024-01-01", StartDate: "2024-01-02", ScheduledDate: "2024-01-03",
+				DueDate: "2024-01-04", DoneDate: "2024-01-05",
+			},
+		},
+		{
+			name:   "metadata in any order",
+			status: " ",
+			text:   "report 📅 2024-02-01 ⏫ 🔁 every week",
+			want:   Task{Status: " ", Text: "report", Priority: TASK_PRIORITY_HIGH, Recurrence: "every week", DueDate: "2024-02-01"},
+		},
+		{
+			name:   "alternative signifiers",
+			status: " ",
+			text:   "a 🗓 2024-03-01 ⌛ 2024-03-02 🔺",
+			want:   Task{Status: " ", Text: "a", Priority: TASK_PRIORITY_HIGHEST, DueDate: "2024-03-01", ScheduledDate: "2024-03-02"},
+		},
+		{
+			name:   "variation selectors",
+			status: "-",
+			text:   "dropped ❌️ 2024-04-01 ⏬️",
+			want:   Task{Status: "-", Text: "dropped", Priority: TASK_PRIORITY_LOWEST, CancelledDate: "2024-04-01"},
+		},
+		{
+			name:   "tags between metadata move to the end",
+			status: " ",
+			text:   "call #work 📅 2024-05-01 #urgent 🔼",
+			want:   Task{Status: " ", Text: "call #work #urgent", Priority: TASK_PRIORITY_MEDIUM, DueDate: "2024-05-01"},
+		},
+		{
+			name:   "trailing tags alone are kept",
+			status: " ",
+			text:   "tidy #home",
+			want:   Task{Status: " ", Text: "tidy #home", Priority: TASK_PRIORITY_NORMAL},
+		},
+		{
+			name:   "metadata before text is not read",
+			status: " ",
+			text:   "📅 2024-06-01 then write",
+			want:   Task{Status: " ", Text: "📅 2024-06-01 then write", Priority: TASK_PRIORITY_NORMAL},
+		},
+		{
+			name:   "block id",
+			status: " ",
+			text:   "linked task 📅 2024-07-01 ^abc-1",
+			want:   Task{Status: " ", Text: "linked task", Priority: TASK_PRIORITY_NORMAL, DueDate: "2024-07-01"},
+		},
+		{
+			name:   "invalid date left in the text",
+			status: " ",
+			text:   "soon 📅 tomorrow",
+			want:   Task{Status: " ", Text: "soon 📅 tomorrow", Priority: TASK_PRIORITY_NORMAL},
+		},
+		{
+			name:   "empty",
+			status: "/",
+			text:   "",
+			want:   Task{Status: "/", Priority: TASK_PRIORITY_NORMAL},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ParseTask(tc.status, tc.text); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseTask(%q, %q) =\n %+v\nwant\n %+v", tc.status, tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTaskFilterValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter TaskFilter
+		ok     bool
+	}{
+		{"due", TaskFilter{Date: TASK_DATE_DUE}, true},
+		{"range", TaskFilter{Date: TASK_DATE_DONE, From: "2024-01-01", To: "2024-12-31"}, true},
+		{"unknown date", TaskFilter{Date: "deadline"}, false},
+		{"bad from", TaskFilter{Date: TASK_DATE_DUE, From: "2024-1-1"}, false},
+		{"bad to", TaskFilter{Date: TASK_DATE_DUE, To: "2024-02-30"}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.filter.Validate(); (err == nil) != tc.ok {
+				t.Errorf("Validate(%+v) = %v; want ok %v", tc.filter, err, tc.ok)
+			}
+		})
+	}
+}
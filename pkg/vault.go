@@ -1,31 +1,422 @@
 package diatom
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
+// Directories never searched for notes
+var SKIPPED_DIRS = map[string]bool{
+	".obsidian":    true,
+	".git":         true,
+	"node_modules": true,
+}
+
+var DEFAULT_INCLUDE = []string{"**/*.md"}
+var DEFAULT_EXCLUDE = []string{".trash/**"}
+
+// A symbolic link found, but not followed, while walking the vault
+type SymlinkError struct {
+	Path string
+}
+
+func (err *SymlinkError) Error() string {
+	return fmt.Sprintf("skipped symlink %v", err.Path)
+}
+
+// The vault folder itself could not be read, so no note in it was seen
+type VaultError struct {
+	Path string
+	Err  error
+}
+
+func (err *VaultError) Error() string {
+	return fmt.Sprintf("cannot read vault %v: %v", err.Path, err.Err)
+}
+
+func (err *VaultError) Unwrap() error {
+	return err.Err
+}
+
+/*
+ * Construct an Obsidian vault representation. Empty include patterns fall
+ * back to the default, and exclude patterns add to the default exclusions.
+ *
+ */
+func NewVault(dpath string, cfg *DiatomConfig) ObsidianVault {
+	include := cfg.Include
+	if len(include) == 0 {
+		include = DEFAULT_INCLUDE
+	}
+
+	exclude := append(append([]string{}, DEFAULT_EXCLUDE...), cfg.Exclude...)
+
+	return ObsidianVault{dpath, include, exclude, cfg.FollowSymlinks}
+}
+
+/*
+ * Match a slash-separated path against a glob pattern. `**` matches
+ * zero or more path segments; a pattern without a slash is matched against
+ * the basename only.
+ *
+ */
+func MatchGlob(pattern, fpath string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(fpath))
+		return matched
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(fpath, "/"))
+}
+
+func matchSegments(patterns, parts []string) bool {
+	if len(patterns) == 0 {
+		return len(parts) == 0
+	}
+
+	if patterns[0] == "**" {
+		for idx := 0; idx <= len(parts); idx++ {
+			if matchSegments(patterns[1:], parts[idx:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+
+	matched, err := path.Match(patterns[0], parts[0])
+	if err != nil || !matched {
+		return false
+	}
+
+	return matchSegments(patterns[1:], parts[1:])
+}
+
+func matchAny(patterns []string, fpath string) bool {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, fpath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Is a vault-relative directory excluded? A directory is excluded when it
+ * matches an exclude pattern outright, or everything beneath it is excluded.
+ *
+ */
+func (vault *ObsidianVault) excludedDir(rel string) bool {
+	if SKIPPED_DIRS[path.Base(rel)] {
+		return true
+	}
+
+	return matchAny(vault.exclude, rel) || matchAny(vault.exclude, rel+"/**")
+}
+
+/*
+ * Is a vault-relative file a note we should read?
+ *
+ */
+func (vault *ObsidianVault) included(rel string) bool {
+	return matchAny(vault.include, rel) && !matchAny(vault.exclude, rel)
+}
+
+/*
+ * Is a vault-relative file an attachment, such as an image or PDF?
+ * Attachments are found anywhere in the vault that is not excluded.
+ *
+ */
+func (vault *ObsidianVault) attachment(rel string) bool {
+	_, ok := AttachmentType(rel)
+	return ok && !matchAny(vault.exclude, rel)
+}
+
+/*
+ * Would a note path be read from this vault?
+ *
+ */
+func (vault *ObsidianVault) Contains(fpath string) bool {
+	return vault.contains(fpath, vault.included)
+}
+
+/*
+ * Would an attachment path be read from this vault?
+ *
+ */
+func (vault *ObsidianVault) ContainsAttachment(fpath string) bool {
+	return vault.contains(fpath, vault.attachment)
+}
+
+func (vault *ObsidianVault) contains(fpath string, wanted func(rel string) bool) bool {
+	rel, err := filepath.Rel(vault.dpath, fpath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+	if vault.beneathExcludedDir(rel) {
+		return false
+	}
+
+	return wanted(rel)
+}
+
+/*
+ * Is a vault-relative path beneath an excluded folder?
+ *
+ */
+func (vault *ObsidianVault) beneathExcludedDir(rel string) bool {
+	parts := strings.Split(rel, "/")
+
+	for idx := 1; idx < len(parts); idx++ {
+		if vault.excludedDir(strings.Join(parts[:idx], "/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
 /*
- * Enumerate all notes in an Obsidian vault.
+ * The vault-relative, slash-separated path a note is stored under
  *
  */
-func (vault *ObsidianVault) GetNotes() ([]string, error) {
-	flatMatches, err := filepath.Glob(filepath.Join(vault.dpath, "*.md"))
+func (vault *ObsidianVault) RelPath(fpath string) string {
+	rel, err := filepath.Rel(vault.dpath, fpath)
 	if err != nil {
-		return []string{}, err
+		return filepath.ToSlash(fpath)
 	}
 
-	nestedMatches, err := filepath.Glob(filepath.Join(vault.dpath, "**/*.md"))
+	return filepath.ToSlash(rel)
+}
+
+/*
+ * The filesystem path of a stored, vault-relative note path
+ *
+ */
+func (vault *ObsidianVault) AbsPath(rel string) string {
+	return filepath.Join(vault.dpath, filepath.FromSlash(rel))
+}
+
+/*
+ * The path of the vault's .obsidian/types.json
+ *
+ */
+func (vault *ObsidianVault) PropertyTypesPath() string {
+	return filepath.Join(vault.dpath, ".obsidian", "types.json")
+}
+
+/*
+ * Read the property types declared in the vault's .obsidian/types.json, keyed
+ * by property name. A vault without the file has no declared types.
+ *
+ */
+func (vault *ObsidianVault) PropertyTypes() (map[string]string, error) {
+	content, err := os.ReadFile(vault.PropertyTypesPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	}
 	if err != nil {
-		return []string{}, err
+		return nil, err
 	}
 
-	filtered := []string{}
-	for _, match := range append(nestedMatches, flatMatches...) {
-		if !strings.HasPrefix(match, filepath.Join(vault.dpath, ".trash")) {
-			filtered = append(filtered, match)
-		}
+	declared := struct {
+		Types map[string]string `json:"types"`
+	}{}
+	if err := json.Unmarshal(content, &declared); err != nil {
+		return nil, fmt.Errorf(".obsidian/types.json: %v", err)
 	}
 
-	return filtered, nil
+	if declared.Types == nil {
+		declared.Types = map[string]string{}
+	}
+
+	return declared.Types, nil
+}
+
+/*
+ * Enumerate all notes in an Obsidian vault, recursively. Paths are streamed
+ * as they are found; symlinks are either followed or reported as a SymlinkError.
+ * A vault folder that cannot be read is reported as a VaultError. The walk stops
+ * early when the context is cancelled.
+ *
+ */
+func (vault *ObsidianVault) GetNotes(ctx context.Context) (<-chan string, <-chan error) {
+	return vault.walk(ctx, vault.included)
+}
+
+/*
+ * Enumerate all attachments in an Obsidian vault, recursively, in the
+ * same way as notes.
+ *
+ */
+func (vault *ObsidianVault) GetAttachments(ctx context.Context) (<-chan string, <-chan error) {
+	return vault.walk(ctx, vault.attachment)
+}
+
+func (vault *ObsidianVault) walk(ctx context.Context, wanted func(rel string) bool) (<-chan string, <-chan error) {
+	notes := make(chan string)
+	errChan := make(chan error)
+
+	go func() {
+		defer close(notes)
+		defer close(errChan)
+
+		// resolved directories already walked, so symlink cycles terminate
+		visited := map[string]bool{}
+
+		// report an error, unless the walk was cancelled and nobody is reading
+		report := func(err error) {
+			select {
+			case errChan <- err:
+			case <-ctx.Done():
+			}
+		}
+
+		var walkDir func(dir, rel string)
+		walkDir = func(dir, rel string) {
+			real, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				if dir == vault.dpath {
+					err = &VaultError{vault.dpath, err}
+				}
+				report(err)
+				return
+			}
+
+			if visited[real] {
+				return
+			}
+			visited[real] = true
+
+			// walk the resolved directory, but report paths beneath the link
+			err = filepath.WalkDir(real, func(target string, entry fs.DirEntry, err error) error {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				if err != nil {
+					if dir == vault.dpath && target == real {
+						err = &VaultError{vault.dpath, err}
+					}
+					report(err)
+					return nil
+				}
+
+				if dir == vault.dpath && target == real && !entry.IsDir() {
+					return &VaultError{vault.dpath, errors.New("not a directory")}
+				}
+
+				subpath, err := filepath.Rel(real, target)
+				if err != nil {
+					return err
+				}
+				fpath := filepath.Join(dir, subpath)
+				relpath := path.Join(rel, filepath.ToSlash(subpath))
+
+				if entry.IsDir() {
+					if target == real {
+						return nil
+					}
+
+					if visited[target] || vault.excludedDir(relpath) {
+						return filepath.SkipDir
+					}
+
+					visited[target] = true
+					return nil
+				}
+
+				if entry.Type()&fs.ModeSymlink != 0 {
+					if !vault.followSymlinks {
+						report(&SymlinkError{fpath})
+						return nil
+					}
+
+					info, err := os.Stat(fpath)
+					if err != nil {
+						report(err)
+						return nil
+					}
+
+					if info.IsDir() {
+						if !vault.excludedDir(relpath) {
+							walkDir(fpath, relpath)
+						}
+						return nil
+					}
+				}
+
+				if wanted(relpath) {
+					select {
+					case notes <- fpath:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+
+				return nil
+			})
+
+			if err != nil && ctx.Err() == nil {
+				report(err)
+			}
+		}
+
+		walkDir(vault.dpath, "")
+	}()
+
+	return notes, errChan
+}
+
+/*
+ * Enumerate the folders beneath a vault folder that may hold notes, including
+ * the folder itself. Symlinked folders are not included, and an excluded
+ * folder, or one beneath an excluded folder, has none.
+ *
+ */
+func (vault *ObsidianVault) GetDirs(root string) ([]string, error) {
+	dirs := []string{}
+
+	err := filepath.WalkDir(root, func(fpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+
+		if fpath == vault.dpath {
+			dirs = append(dirs, fpath)
+			return nil
+		}
+
+		rel, err := filepath.Rel(vault.dpath, fpath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if vault.excludedDir(rel) || (fpath == root && vault.beneathExcludedDir(rel)) {
+			return filepath.SkipDir
+		}
+
+		dirs = append(dirs, fpath)
+		return nil
+	})
+
+	return dirs, err
 }
@@ -0,0 +1,249 @@
+package diatom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		fpath   string
+		want    bool
+	}{
+		{"**/*.md", "a.md", true},
+		{"**/*.md", "x/a.md", true},
+		{"**/*.md", "x/y/a.md", true},
+		{"**/*.md", "a.txt", false},
+		{"**/*.md", "x/a.md.bak", false},
+		{".trash/**", ".trash", true},
+		{".trash/**", ".trash/a.md", true},
+		{".trash/**", ".trash/x/a.md", true},
+		{".trash/**", "x/.trash/a.md", false},
+		{".trash/**", ".trashed/a.md", false},
+		{"a/**/b.md", "a/b.md", true},
+		{"a/**/b.md", "a/x/b.md", true},
+		{"a/**/b.md", "a/x/y/b.md", true},
+		{"a/**/b.md", "b.md", false},
+		{"a/**/b.md", "a/x/c.md", false},
+		{"a/**/b.md", "z/a/x/b.md", false},
+		{"*.md", "a.md", true},
+		{"*.md", "x/y/a.md", true},
+		{"draft-*", "x/draft-1.md", true},
+		{"draft-*", "draft/a.md", false},
+		{"x/*.md", "x/a.md", true},
+		{"x/*.md", "x/y/a.md", false},
+		{"[", "a.md", false},
+	}
+
+	for _, tc := range cases {
+		if got := MatchGlob(tc.pattern, tc.fpath); got != tc.want {
+			t.Errorf("MatchGlob(%q, %q) = %v; want %v", tc.pattern, tc.fpath, got, tc.want)
+		}
+	}
+}
+
+func TestVaultExcludes(t *testing.T) {
+	vault := NewVault("/vault", &DiatomConfig{Exclude: []string{"archive/**", "*.excalidraw.md"}})
+
+	cases := []struct {
+		fpath string
+		want  bool
+	}{
+		{"/vault/a.md", true},
+		{"/vault/x/a.md", true},
+		{"/vault/.trash/a.md", false},
+		{"/vault/archive/a.md", false},
+		{"/vault/x/archive/a.md", true},
+		{"/vault/x/drawing.excalidraw.md", false},
+		{"/vault/.obsidian/a.md", false},
+		{"/vault/a.txt", false},
+		{"/elsewhere/a.md", false},
+	}
+
+	for _, tc := range cases {
+		if got := vault.Contains(tc.fpath); got != tc.want {
+			t.Errorf("Contains(%q) = %v; want %v", tc.fpath, got, tc.want)
+		}
+	}
+
+	for _, rel := range []string{".trash", "archive", ".obsidian"} {
+		if !vault.excludedDir(rel) {
+			t.Errorf("excludedDir(%q) = false; want true", rel)
+		}
+	}
+}
+
+func TestGetDirs(t *testing.T) {
+	dir := writeVault(t, map[string]string{
+		"a.md":                        "",
+		"notes/sub/b.md":              "",
+		"archive/old/c.md":            "",
+		".git/objects/ab/object":      "",
+		"x/node_modules/pkg/index.js": "",
+	})
+	vault := NewVault(dir, &DiatomConfig{Exclude: []string{"archive/**"}})
+
+	cases := []struct {
+		name string
+		root string
+		want []string
+	}{
+		{"the vault", "", []string{"", "notes", "notes/sub", "x"}},
+		{"a new folder", "notes", []string{"notes", "notes/sub"}},
+		{"a skipped folder", ".git", []string{}},
+		{"beneath a skipped folder", ".git/objects", []string{}},
+		{"a nested skipped folder", "x/node_modules", []string{}},
+		{"beneath an excluded folder", "archive/old", []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dirs, err := vault.GetDirs(filepath.Join(dir, filepath.FromSlash(tc.root)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, fpath := range dirs {
+				rel := vault.RelPath(fpath)
+				if rel == "." {
+					rel = ""
+				}
+				got = append(got, rel)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("GetDirs(%q) = %v; want %v", tc.root, got, tc.want)
+			}
+		})
+	}
+}
+
+/*
+ * Read every path and error a walk streams until both channels close, failing
+ * the test if the walk does not end
+ *
+ */
+func drainWalk(t *testing.T, vault *ObsidianVault, notes <-chan string, errs <-chan error) ([]string, []error) {
+	t.Helper()
+
+	rels := []string{}
+	found := []error{}
+	timeout := time.After(10 * time.Second)
+
+	for notes != nil || errs != nil {
+		select {
+		case fpath, ok := <-notes:
+			if !ok {
+				notes = nil
+				continue
+			}
+			rels = append(rels, vault.RelPath(fpath))
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			found = append(found, err)
+		case <-timeout:
+			t.Fatal("the walk did not end")
+		}
+	}
+
+	sort.Strings(rels)
+	return rels, found
+}
+
+func TestGetNotes(t *testing.T) {
+	dir := writeVault(t, map[string]string{
+		"a.md":                "",
+		"notes/sub/b.md":      "",
+		"notes/c.txt":         "",
+		"x/node_modules/d.md": "",
+		".trash/e.md":         "",
+	})
+	outside := writeVault(t, map[string]string{"f.md": ""})
+
+	// a folder linked from outside the vault, and two symlink loops
+	links := map[string]string{
+		"linked":     outside,
+		"notes/loop": filepath.Join(dir, "notes"),
+	}
+	for rel, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(rel))); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(outside, "back")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		follow   bool
+		want     []string
+		symlinks []string
+	}{
+		{"symlinks reported", false, []string{"a.md", "notes/sub/b.md"}, []string{"linked", "notes/loop"}},
+		{"symlinks followed", true, []string{"a.md", "linked/f.md", "notes/sub/b.md"}, []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			vault := NewVault(dir, &DiatomConfig{FollowSymlinks: tc.follow})
+
+			notes, errs := vault.GetNotes(context.Background())
+			got, found := drainWalk(t, &vault, notes, errs)
+
+			symlinks := []string{}
+			for _, err := range found {
+				var symlinkErr *SymlinkError
+				if !errors.As(err, &symlinkErr) {
+					t.Errorf("GetNotes() error %v; want only symlink errors", err)
+					continue
+				}
+				symlinks = append(symlinks, vault.RelPath(symlinkErr.Path))
+			}
+			sort.Strings(symlinks)
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("GetNotes() = %v; want %v", got, tc.want)
+			}
+			if !reflect.DeepEqual(symlinks, tc.symlinks) {
+				t.Errorf("GetNotes() skipped symlinks %v; want %v", symlinks, tc.symlinks)
+			}
+		})
+	}
+}
+
+func TestGetNotesCancelled(t *testing.T) {
+	notes := map[string]string{}
+	for idx := 0; idx < 100; idx++ {
+		notes[fmt.Sprintf("dir%v/note%v.md", idx%10, idx)] = ""
+	}
+	dir := writeVault(t, notes)
+	vault := NewVault(dir, &DiatomConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	paths, errs := vault.GetNotes(ctx)
+
+	// cancel once the first note is read; the walk stops, closing both channels
+	<-paths
+	cancel()
+
+	got, found := drainWalk(t, &vault, paths, errs)
+	if len(got)+1 >= len(notes) {
+		t.Errorf("GetNotes() streamed %v more notes after cancellation; want the walk to stop", len(got))
+	}
+	if len(found) != 0 {
+		t.Errorf("GetNotes() errors after cancellation = %v; want none", found)
+	}
+}
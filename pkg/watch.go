@@ -0,0 +1,422 @@
+package diatom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Incrementally reindexes a vault as its files change
+type Watcher struct {
+	Args     *DiatomArgs
+	Debounce time.Duration
+	Out      io.Writer
+
+	conn    *ObsidianDB
+	vault   *ObsidianVault
+	watcher *fsnotify.Watcher
+}
+
+/*
+ * Index a vault, then keep the database up to date as notes are created,
+ * written, renamed and deleted. Notes that fail to index are reported, and
+ * retried when they next change. Runs until the context is cancelled, or the
+ * watcher fails.
+ *
+ */
+func Watch(ctx context.Context, args *DiatomArgs, debounce time.Duration, out io.Writer) error {
+	var runErrors *RunErrors
+
+	if err := Diatom(ctx, args); errors.As(err, &runErrors) {
+		WriteErrorSummary(err, out)
+	} else if err != nil {
+		return err
+	}
+
+	conn, err := NewDB(args.Config.DBPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsWatcher.Close()
+
+	vault := NewVault(args.Dir, args.Config)
+	watcher := Watcher{
+		Args:     args,
+		Debounce: debounce,
+		Out:      out,
+		conn:     &conn,
+		vault:    &vault,
+		watcher:  fsWatcher,
+	}
+
+	if _, err := watcher.AddDirs(vault.dpath); err != nil {
+		return err
+	}
+
+	// .obsidian holds no notes, but its types.json types every note's properties
+	settings := filepath.Dir(vault.PropertyTypesPath())
+	if info, err := os.Stat(settings); err == nil && info.IsDir() {
+		if err := fsWatcher.Add(settings); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(out, "watching %v\n", vault.dpath)
+	return watcher.Loop(ctx)
+}
+
+/*
+ * Watch a folder and each folder beneath it, except excluded folders, and
+ * return the folders watched
+ *
+ */
+func (watcher *Watcher) AddDirs(dpath string) ([]string, error) {
+	dirs, err := watcher.vault.GetDirs(dpath)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, dir := range dirs {
+		if err := watcher.watcher.Add(dir); err != nil {
+			return dirs[:idx], err
+		}
+	}
+
+	return dirs, nil
+}
+
+/*
+ * Collect file events until the vault is quiet for the debounce period,
+ * then reindex the affected paths. Of .obsidian, only changes to types.json
+ * are collected. Stops once the context is cancelled.
+ *
+ */
+func (watcher *Watcher) Loop(ctx context.Context) error {
+	pending := map[string]bool{}
+	typesPath := watcher.vault.PropertyTypesPath()
+
+	timer := time.NewTimer(watcher.Debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			// Obsidian writes its workspace and other settings often, so skip them
+			if filepath.Dir(event.Name) == filepath.Dir(typesPath) && event.Name != typesPath {
+				continue
+			}
+
+			// a new .obsidian is watched only for types.json
+			if event.Name == filepath.Dir(typesPath) {
+				if event.Op&fsnotify.Create != 0 {
+					if err := watcher.watcher.Add(event.Name); err != nil {
+						fmt.Fprintf(watcher.Out, "diatom: %v\n", err)
+					}
+				}
+				pending[typesPath] = true
+				resetTimer(timer, watcher.Debounce)
+				continue
+			}
+
+			// fsnotify does not watch recursively, so watch new folders and index their notes
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					// an excluded folder, such as one made by git init, has no folders to watch
+					dirs, err := watcher.AddDirs(event.Name)
+					if err != nil {
+						fmt.Fprintf(watcher.Out, "diatom: %v\n", err)
+					}
+
+					for _, dir := range dirs {
+						entries, _ := os.ReadDir(dir)
+						for _, entry := range entries {
+							if !entry.IsDir() {
+								pending[filepath.Join(dir, entry.Name())] = true
+							}
+						}
+					}
+				}
+			}
+
+			pending[event.Name] = true
+			resetTimer(timer, watcher.Debounce)
+		case err, ok := <-watcher.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(watcher.Out, "diatom: %v\n", err)
+		case <-timer.C:
+			paths := []string{}
+			for fpath := range pending {
+				paths = append(paths, fpath)
+			}
+			pending = map[string]bool{}
+
+			if err := watcher.Reindex(ctx, paths); err != nil {
+				fmt.Fprintf(watcher.Out, "diatom: %v\n", err)
+			}
+		}
+	}
+}
+
+/*
+ * Restart a timer, discarding a tick not yet read. Before Go 1.23, Reset
+ * keeps a fired timer's tick, which would end the debounce at once.
+ *
+ */
+func resetTimer(timer *time.Timer, duration time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+
+	timer.Reset(duration)
+}
+
+/*
+ * Reindex changed notes, remove deleted ones, and update link resolutions
+ * and degrees for the affected neighbourhood of the graph. A changed
+ * types.json also reindexes every note whose properties it retypes.
+ *
+ */
+func (watcher *Watcher) Reindex(ctx context.Context, paths []string) error {
+	conn := watcher.conn
+	vault := watcher.vault
+
+	stored, err := conn.GetFilePaths()
+	if err != nil {
+		return err
+	}
+
+	known := map[string]string{}
+	for fileId, rel := range stored {
+		known[rel] = fileId
+	}
+
+	changed := []string{}
+	removed := []string{}
+	vanished := []string{}
+	attachmentsTouched := false
+	typesChanged := false
+
+	for _, fpath := range paths {
+		if fpath == vault.PropertyTypesPath() {
+			typesChanged = true
+			continue
+		}
+
+		info, err := os.Stat(fpath)
+		rel := vault.RelPath(fpath)
+
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// a removed or renamed-away folder takes its notes with it
+			isNote := false
+			for _, storedPath := range stored {
+				if storedPath == rel || strings.HasPrefix(storedPath, rel+"/") {
+					vanished = append(vanished, storedPath)
+					isNote = isNote || storedPath == rel
+				}
+			}
+
+			// and may take attachments with it
+			attachmentsTouched = attachmentsTouched || !isNote
+		case err != nil:
+			return err
+		case info.IsDir():
+			continue
+		case vault.Contains(fpath):
+			changed = append(changed, fpath)
+		case vault.ContainsAttachment(fpath):
+			attachmentsTouched = true
+		case known[rel] != "":
+			// no longer part of the vault, because of the exclude patterns
+			removed = append(removed, rel)
+		}
+	}
+
+	// new types mark the notes they retype as stale; an unreadable types.json keeps the types last read
+	if typesChanged {
+		types, err := vault.PropertyTypes()
+		if err != nil {
+			fmt.Fprintf(watcher.Out, "diatom: %v\n", err)
+		} else if err := conn.UpdatePropertyTypes(types); err != nil {
+			return err
+		}
+
+		stale, err := conn.GetStalePaths()
+		if err != nil {
+			return err
+		}
+
+		queued := map[string]bool{}
+		for _, fpath := range changed {
+			queued[fpath] = true
+		}
+
+		for _, rel := range stale {
+			if fpath := vault.AbsPath(rel); !queued[fpath] {
+				changed = append(changed, fpath)
+			}
+		}
+	}
+
+	if len(changed) == 0 && len(removed) == 0 && len(vanished) == 0 && !attachmentsTouched {
+		return nil
+	}
+
+	// rewriting or deleting a note drops its links, so note what they resolved to first
+	previousIds := []string{}
+	for _, rel := range append(append([]string{}, removed...), vanished...) {
+		previousIds = append(previousIds, known[rel])
+	}
+	for _, fpath := range changed {
+		if fileId := known[vault.RelPath(fpath)]; fileId != "" {
+			previousIds = append(previousIds, fileId)
+		}
+	}
+
+	previouslyLinked, err := conn.GetLinkedFileIds(previousIds)
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range removed {
+		if err := conn.DeleteFile(known[rel]); err != nil {
+			return err
+		}
+	}
+
+	// vanished notes may reappear under a new path in the same burst of changes
+	renames, err := conn.GetRenameCandidates(vanished)
+	if err != nil {
+		return err
+	}
+
+	notes := make(chan string)
+	go func() {
+		defer close(notes)
+
+		for _, fpath := range changed {
+			notes <- fpath
+		}
+	}()
+
+	stats := NewStats()
+	if err := IndexNotes(ctx, conn, vault, watcher.Args.Config, stats, renames, notes); err != nil {
+		return err
+	}
+
+	if err := stats.Err(); err != nil {
+		WriteErrorSummary(err, watcher.Out)
+	}
+
+	// leave vanished notes for the next run, which may still find them renamed;
+	// the links of notes already written are still resolved
+	if ctx.Err() == nil {
+		unclaimed := renames.Unclaimed()
+		for _, rel := range unclaimed {
+			if err := conn.DeletePath(rel); err != nil {
+				return err
+			}
+		}
+		removed = append(removed, unclaimed...)
+	}
+
+	if err := conn.AssignFrontmatterIds(); err != nil {
+		return err
+	}
+
+	// unchanged attachments are not re-read, so rescanning them all is cheap
+	attachmentPaths := []string{}
+	if attachmentsTouched {
+		attachers := AttachmentWorker{
+			Stats:  NewStats(),
+			Config: watcher.Args.Config,
+			Vault:  vault,
+		}
+
+		attachmentPaths, err = attachers.Start(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if err := attachers.Stats.Err(); err != nil {
+			WriteErrorSummary(err, watcher.Out)
+		}
+	}
+
+	written, err := conn.GetFilePaths()
+	if err != nil {
+		return err
+	}
+
+	// the links of notes at any touched path, before or after, may resolve differently
+	touched := append(append(append([]string{}, removed...), vanished...), attachmentPaths...)
+	for _, fpath := range changed {
+		touched = append(touched, vault.RelPath(fpath))
+	}
+
+	touchedPaths := map[string]bool{}
+	for _, rel := range touched {
+		touchedPaths[rel] = true
+	}
+
+	changedIds := []string{}
+	for _, files := range []map[string]string{stored, written} {
+		for fileId, rel := range files {
+			if touchedPaths[rel] {
+				changedIds = append(changedIds, fileId)
+			}
+		}
+	}
+
+	neighbourhood, err := ResolveNeighbourhood(conn, changedIds, touched)
+	if err != nil {
+		return err
+	}
+
+	// notes that lost a link from a rewritten or deleted note
+	inNeighbourhood := map[string]bool{}
+	for _, fileId := range neighbourhood {
+		inNeighbourhood[fileId] = true
+	}
+	for _, fileId := range previouslyLinked {
+		if !inNeighbourhood[fileId] {
+			inNeighbourhood[fileId] = true
+			neighbourhood = append(neighbourhood, fileId)
+		}
+	}
+
+	// deleted notes have no row left, so are skipped
+	if err := conn.UpdateDegrees(neighbourhood); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(watcher.Out, "%v: updated %v notes, renamed %v, removed %v, updated %v attachments, refreshed degrees for %v\n",
+		time.Now().Format(time.Kitchen), stats.Data[COUNT_NOTE_WRITTEN], stats.Data[COUNT_NOTE_RENAMED], len(removed),
+		len(attachmentPaths), len(neighbourhood))
+
+	return nil
+}
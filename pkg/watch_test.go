@@ -0,0 +1,120 @@
+package diatom
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReindexRenameAndDelete(t *testing.T) {
+	dir := writeVault(t, map[string]string{
+		"a.md": "[[b]] [[c]]\n",
+		"b.md": "text\n",
+		"c.md": "[[a]]\n",
+		"d.md": "[[renamed]]\n",
+	})
+	dbpath := filepath.Join(t.TempDir(), "diatom.sqlite")
+	indexVault(t, dir, dbpath, EXTRACTORS)
+
+	conn, err := NewDB(dbpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DBPath = dbpath
+
+	vault := NewVault(dir, cfg)
+	watcher := Watcher{
+		Args:  &DiatomArgs{Dir: dir, Config: cfg},
+		Out:   io.Discard,
+		conn:  &conn,
+		vault: &vault,
+	}
+
+	before := storedIds(t, &conn)
+
+	// b.md is renamed, so a.md's link breaks and d.md's resolves; c.md is deleted
+	if err := os.Rename(filepath.Join(dir, "b.md"), filepath.Join(dir, "renamed.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "c.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{filepath.Join(dir, "b.md"), filepath.Join(dir, "renamed.md"), filepath.Join(dir, "c.md")}
+	if err := watcher.Reindex(context.Background(), paths); err != nil {
+		t.Fatalf("Reindex(): %v", err)
+	}
+
+	ids := storedIds(t, &conn)
+	if _, ok := ids["c.md"]; ok {
+		t.Errorf("c.md is still stored after its deletion")
+	}
+	if ids["renamed.md"] != before["b.md"] {
+		t.Errorf("renamed.md has id %q; want b.md's id %q, kept across the rename", ids["renamed.md"], before["b.md"])
+	}
+
+	// each link's target, and the path it resolves to
+	resolutions := map[string]string{}
+	rows, err := conn.Db.Query(`
+	select source.path || ' -> ' || wikilink.target, coalesce(target.path, '')
+		from wikilink
+	join file as source on source.id = wikilink.file_id
+	left join file as target on target.id = wikilink.resolved_file_id
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var link, resolved string
+		if err := rows.Scan(&link, &resolved); err != nil {
+			t.Fatal(err)
+		}
+		resolutions[link] = resolved
+	}
+	rows.Close()
+
+	wantResolutions := map[string]string{
+		"a.md -> b":       "",
+		"a.md -> c":       "",
+		"d.md -> renamed": "renamed.md",
+	}
+	for link, want := range wantResolutions {
+		if got, ok := resolutions[link]; !ok || got != want {
+			t.Errorf("%v resolves to %q; want %q", link, got, want)
+		}
+	}
+
+	degrees := map[string][2]int{}
+	rows, err = conn.Db.Query(`select path, in_degree, out_degree from file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var fpath string
+		var in, out int
+		if err := rows.Scan(&fpath, &in, &out); err != nil {
+			t.Fatal(err)
+		}
+		degrees[fpath] = [2]int{in, out}
+	}
+	rows.Close()
+
+	wantDegrees := map[string][2]int{
+		"a.md":       {0, 2},
+		"renamed.md": {1, 0},
+		"d.md":       {0, 1},
+	}
+	for fpath, want := range wantDegrees {
+		if degrees[fpath] != want {
+			t.Errorf("%v has in and out degrees %v; want %v", fpath, degrees[fpath], want)
+		}
+	}
+}
@@ -1,26 +1,38 @@
 package diatom
 
 import (
+	"context"
+	"os"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
 )
 
 /*
- * Extract workers manager definition
+ * Extract workers manager definition. Workers parse notes into results,
+ * and never write to the database themselves.
  *
  */
 type ExtractWorkers struct {
-	Stats *Stats
-	Jobs  chan string
-	Count int
+	Stats   *Stats
+	Config  *DiatomConfig
+	Vault   *ObsidianVault
+	Files   map[string]FileState
+	Renames *RenameCandidates
+	// property types declared in .obsidian/types.json
+	PropertyTypes map[string]string
+	Jobs          chan string
+	Results       chan *NoteResult
 }
 
 /*
- * take the channel, read jobs, write metadata to sqlite.
+ * take the channel, read jobs, send extracted note data to the results channel.
+ * Once the context is cancelled, a note in progress is finished and remaining
+ * jobs are skipped.
  *
  */
-func (work *ExtractWorkers) AnalyseNote(conn *ObsidianDB) <-chan error {
+func (work *ExtractWorkers) AnalyseNote(ctx context.Context) <-chan error {
 	errChan := make(chan error)
 
 	// writes to errchan
@@ -29,44 +41,83 @@ func (work *ExtractWorkers) AnalyseNote(conn *ObsidianDB) <-chan error {
 
 		// extract information for each file
 		for fpath := range work.Jobs {
+			if ctx.Err() != nil {
+				continue
+			}
+
 			work.Stats.Add(COUNT_EXTRACT_NOTE)
 			note := NewNote(fpath)
+			rel := work.Vault.RelPath(fpath)
+			stored, known := work.Files[rel]
 
-			// walk through markdown note
-			walkFailed := false
-			for err := range note.Walk(conn) {
-				walkFailed = true
-				errChan <- err
+			info, err := os.Stat(fpath)
+			if err != nil {
+				work.Stats.Add(COUNT_FAILED_EXTRACTION)
+				errChan <- &NoteError{rel, FAILURE_READ, err}
+				continue
 			}
 
-			// note extraction failed, ignore this note.
-			if walkFailed {
-				work.Stats.Add(COUNT_FAILED_WALK)
+			mtime, size := info.ModTime().UnixNano(), info.Size()
+
+			// an unchanged size and mtime means an unchanged note, unless asked to verify
+			// or it must be read with new property types or extractors
+			if known && !work.Config.Verify && !stored.Stale && stored.Mtime == mtime && stored.Size == size {
+				work.Stats.Add(COUNT_NOTE_CACHED)
 				continue
 			}
 
-			// extract data from the note
-			done, err := note.ExtractData(conn)
-
+			text, err := note.Read()
 			if err != nil {
 				work.Stats.Add(COUNT_FAILED_EXTRACTION)
-				errChan <- errors.Wrap(err, "failure extracting data")
+				errChan <- &NoteError{rel, FAILURE_READ, err}
 				continue
 			}
 
 			// if we have analysed this file-hash already; assume the
 			// database contains all relevant information for this file
-			if done {
+			if known && !stored.Stale && !note.Changed(text, stored.Hash) {
 				work.Stats.Add(COUNT_NOTE_CACHED)
+
+				if stored.Mtime != mtime || stored.Size != size {
+					work.Results <- &NoteResult{Path: rel, Mtime: mtime, Size: size, StatOnly: true}
+				}
+				continue
+			}
+
+			// extract data from the note
+			if err := note.ExtractData(text, work.Config); err != nil {
+				work.Stats.Add(COUNT_FAILED_EXTRACTION)
+				errChan <- &NoteError{rel, FAILURE_EXTRACT, err}
 				continue
-			} else {
-				work.Stats.Add(COUNT_NOTE_UPDATED)
 			}
 
-			// sends errors to errChan
-			for err := range note.Write(conn) {
-				errChan <- err
+			// walk through markdown note; if this fails, ignore this note.
+			if err := note.Walk(work.Config); err != nil {
+				work.Stats.Add(COUNT_FAILED_WALK)
+				errChan <- &NoteError{rel, FAILURE_WALK, err}
+				continue
 			}
+
+			if work.Config.Enabled(EXTRACTOR_PROPERTIES) {
+				note.ReadProperties(work.PropertyTypes)
+			}
+
+			work.Stats.Add(COUNT_NOTE_UPDATED)
+			result := note.Result(rel)
+			result.Mtime, result.Size = mtime, size
+			result.Added = !known
+
+			// a new note may be a vanished note, renamed
+			if !known && strings.TrimSpace(text) != "" {
+				if from, reason, ok := work.Renames.Claim(result.Data.Hash, result.FrontmatterId); ok {
+					work.Stats.Add(COUNT_NOTE_RENAMED)
+					result.Added = false
+					result.RenamedFrom = from
+					result.RenameReason = reason
+				}
+			}
+
+			work.Results <- result
 		}
 	}()
 
@@ -74,24 +125,27 @@ func (work *ExtractWorkers) AnalyseNote(conn *ObsidianDB) <-chan error {
 }
 
 /*
- * Extract note information into a SQLite database.
+ * Extract note information, and send it to the results channel. No new
+ * notes are started once the context is cancelled.
  *
  */
-func (work *ExtractWorkers) Start(conn *ObsidianDB, markdownFiles []string) <-chan error {
+func (work *ExtractWorkers) Start(ctx context.Context, markdownFiles <-chan string) <-chan error {
 	var wg sync.WaitGroup
-	wg.Add(work.Count)
+	count := work.Config.Workers
+	wg.Add(count)
 
-	results := make(chan error, work.Count)
+	results := make(chan error, count)
 
 	// wait to start note extractors
 	go func() {
 		defer close(results)
+		defer close(work.Results)
 
 		// start workers to process files, and feed erros into a aggregated error channel
-		for procId := 0; procId < work.Count; procId++ {
+		for procId := 0; procId < count; procId++ {
 			// start extract worker, forward results
 			go func() {
-				for err := range work.AnalyseNote(conn) {
+				for err := range work.AnalyseNote(ctx) {
 					results <- err
 				}
 
@@ -106,8 +160,16 @@ func (work *ExtractWorkers) Start(conn *ObsidianDB, markdownFiles []string) <-ch
 	go func() {
 		defer close(work.Jobs)
 
-		for _, fpath := range markdownFiles {
-			work.Jobs <- fpath
+		for fpath := range markdownFiles {
+			// keep draining, so the vault walk is not left blocked
+			if ctx.Err() != nil {
+				continue
+			}
+
+			select {
+			case work.Jobs <- fpath:
+			case <-ctx.Done():
+			}
 		}
 	}()
 
@@ -116,6 +178,170 @@ func (work *ExtractWorkers) Start(conn *ObsidianDB, markdownFiles []string) <-ch
 
 // ================================================ //
 
+type WriteWorker struct {
+	Stats     *Stats
+	BatchSize int
+}
+
+/*
+ * Start the single database writer. Note results are applied in
+ * batched transactions; each note is written fully, or not at all. The writer
+ * is not cancellable; it writes every result extracted before a cancellation.
+ *
+ */
+func (worker *WriteWorker) Start(conn *ObsidianDB, results <-chan *NoteResult) <-chan error {
+	errChan := make(chan error)
+
+	go func() {
+		defer close(errChan)
+
+		stmts, err := conn.PrepareStatements()
+		if err != nil {
+			errChan <- errors.Wrap(err, "failure preparing statements")
+
+			// drain results so extract workers are not blocked
+			for range results {
+			}
+			return
+		}
+		defer stmts.Close()
+
+		batch := []*NoteResult{}
+
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+
+			failures, err := conn.WriteNotes(stmts, batch)
+
+			for fpath, failure := range failures {
+				worker.Stats.Add(COUNT_FAILED_WRITE)
+				errChan <- &NoteError{fpath, FAILURE_WRITE, failure}
+			}
+
+			if err != nil {
+				errChan <- errors.Wrap(err, "failure writing batch")
+			} else {
+				for _, result := range batch {
+					if _, failed := failures[result.Path]; failed || result.StatOnly {
+						continue
+					}
+
+					worker.Stats.Add(COUNT_NOTE_WRITTEN)
+					if result.Added {
+						worker.Stats.AddFile(result.Path, FILE_ADDED)
+					} else {
+						worker.Stats.AddFile(result.Path, FILE_CHANGED)
+					}
+				}
+			}
+
+			batch = []*NoteResult{}
+		}
+
+		for result := range results {
+			batch = append(batch, result)
+
+			if len(batch) >= worker.BatchSize {
+				flush()
+			}
+		}
+
+		flush()
+	}()
+
+	return errChan
+}
+
+/*
+ * Merge error channels into one, closed once every input is closed
+ *
+ */
+func MergeErrors(chans ...<-chan error) <-chan error {
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+
+	merged := make(chan error)
+
+	for _, errChan := range chans {
+		go func(errChan <-chan error) {
+			defer wg.Done()
+
+			for err := range errChan {
+				merged <- err
+			}
+		}(errChan)
+	}
+
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged
+}
+
+// ================================================ //
+
+type ResolveWorker struct {
+	Stats *Stats
+}
+
+/*
+ * Resolve each wikilink's target to a note, or an attachment
+ *
+ */
+func (worker *ResolveWorker) Start(conn *ObsidianDB) error {
+	paths, err := conn.GetFilePaths()
+	if err != nil {
+		return errors.Wrap(err, "failure reading file paths")
+	}
+
+	attachments, err := conn.GetAttachmentPaths()
+	if err != nil {
+		return errors.Wrap(err, "failure reading attachment paths")
+	}
+
+	index := NewLinkIndex(paths, attachments)
+
+	rows, err := conn.GetWikilinkTargets()
+	if err != nil {
+		return errors.Wrap(err, "failure reading wikilinks")
+	}
+
+	resolutions := map[int64]Resolution{}
+
+	for rows.Next() {
+		var rowid int64
+		var target, fileId string
+
+		if err := rows.Scan(&rowid, &target, &fileId); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "failure reading wikilinks")
+		}
+
+		resolved := index.ResolveLink(target, fileId)
+		if resolved.Resolved() {
+			worker.Stats.Add(COUNT_LINK_RESOLVED)
+		} else {
+			worker.Stats.Add(COUNT_LINK_UNRESOLVED)
+		}
+
+		resolutions[rowid] = resolved
+	}
+
+	if err := rows.Close(); err != nil {
+		return errors.Wrap(err, "failure reading wikilinks")
+	}
+
+	if err := conn.UpdateWikilinkResolutions(resolutions); err != nil {
+		return errors.Wrap(err, "failure saving wikilink resolutions")
+	}
+
+	return nil
+}
+
 type GraphWorker struct {
 	Stats *Stats
 }
@@ -125,56 +351,81 @@ type GraphWorker struct {
  * file
  *
  */
-func (worker *GraphWorker) Start(conn *ObsidianDB) {
+func (worker *GraphWorker) Start(conn *ObsidianDB) error {
+	// each job sends at most one error before closing its channel
 	for err := range InDegreeJob(conn) {
-		panic(err)
+		return err
 	}
 
 	for err := range OutDegreeJob(conn) {
-		panic(err)
+		return err
 	}
+
+	return nil
 }
 
 type RemoveWorker struct {
 	Stats *Stats
+	Vault *ObsidianVault
 }
 
 /*
+ * Remove notes that are no longer part of the vault because of the include &
+ * exclude patterns. Notes that no longer exist may have been renamed, so are
+ * returned as rename candidates rather than removed.
  *
  */
-func (worker *RemoveWorker) Start(conn *ObsidianDB) {
-	// find removed files and delete
-
-	fpaths, err := conn.GetFileIds()
+func (worker *RemoveWorker) Start(conn *ObsidianDB) (*RenameCandidates, error) {
+	paths, err := conn.GetFilePaths()
 	if err != nil {
-		panic(err)
+		return nil, errors.Wrap(err, "failure reading file paths")
 	}
 
-	tgts := []string{}
+	vanished := []string{}
 
-	for _, fpath := range fpaths {
+	for fileId, rel := range paths {
+		fpath := worker.Vault.AbsPath(rel)
 		note := NewNote(fpath)
+
+		// a note we cannot stat is left in place, and reported
 		exists, err := note.Exists()
 		if err != nil {
-			panic(err)
+			worker.Stats.Fail(&NoteError{rel, FAILURE_REMOVE, err})
+			continue
 		}
 
 		if !exists {
-			tgts = append(tgts, fpath)
-			err = note.Delete(conn)
-			if err != nil {
-				panic(err)
+			vanished = append(vanished, rel)
+			continue
+		}
+
+		if !worker.Vault.Contains(fpath) {
+			if err := conn.DeleteFile(fileId); err != nil {
+				return nil, errors.Wrapf(err, "failure removing %v", rel)
 			}
+			worker.Stats.AddFile(rel, FILE_DELETED)
 		}
 	}
 
-	for _, fpath := range tgts {
-		note := NewNote(fpath)
-		tgts = append(tgts, fpath)
+	candidates, err := conn.GetRenameCandidates(vanished)
+	if err != nil {
+		return nil, errors.Wrap(err, "failure reading rename candidates")
+	}
 
-		err = note.Delete(conn)
-		if err != nil {
-			panic(err)
+	return candidates, nil
+}
+
+/*
+ * Remove vanished notes that were not renamed
+ *
+ */
+func (worker *RemoveWorker) Finish(conn *ObsidianDB, candidates *RenameCandidates) error {
+	for _, rel := range candidates.Unclaimed() {
+		if err := conn.DeletePath(rel); err != nil {
+			return errors.Wrapf(err, "failure removing %v", rel)
 		}
+		worker.Stats.AddFile(rel, FILE_DELETED)
 	}
+
+	return nil
 }